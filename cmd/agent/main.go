@@ -2,21 +2,91 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"time"
 	"workshop3_dev/internals/agent"
+	"workshop3_dev/internals/config"
+	"workshop3_dev/internals/signing"
+)
+
+// Baked in at build time with
+// go build -ldflags "-X main.pinnedFingerprint=<hex> -X main.taskPublicKey=<base64>" ./cmd/agent
+var (
+	pinnedFingerprint string // SHA-256 of the server CA or listener certificate
+	taskPublicKey     string // Engagement key that every job must be signed with
 )
 
 func main() {
 
-	serverAddr := "192.168.2.11:8443"
-	delay := 5 * time.Second
-	jitter := 50
+	configPath := flag.String("config", "", "path to the agent YAML config (defaults are used if omitted)")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration, print it and exit")
+	serverAddrFlag := flag.String("server", "", "override server_addr")
+	delayFlag := flag.Duration("delay", 0, "override delay")
+	jitterFlag := flag.Int("jitter", -1, "override jitter percentage")
+	flag.Parse()
+
+	cfg, err := config.LoadAgent(*configPath)
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+
+	// Flags win over the config file
+	if *serverAddrFlag != "" {
+		cfg.ServerAddr = *serverAddrFlag
+	}
+	if *delayFlag != 0 {
+		cfg.Delay = *delayFlag
+	}
+	if *jitterFlag != -1 {
+		cfg.Jitter = *jitterFlag
+	}
+	if pinnedFingerprint != "" {
+		cfg.PinSHA256 = pinnedFingerprint
+	}
+	if taskPublicKey != "" {
+		cfg.TaskPublicKey = taskPublicKey
+	}
+
+	if err := cfg.Validate(); err != nil {
+		if *checkConfig {
+			fmt.Printf("Configuration is invalid:\n%v\n", err)
+			os.Exit(1)
+		}
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	if *checkConfig {
+		fmt.Printf("Configuration OK\n\n%s", config.Dump(cfg))
+		return
+	}
+
+	serverAddr := cfg.ServerAddr
+	delay := cfg.Delay
+	jitter := cfg.Jitter
+
+	if cfg.Protocol == "https" && cfg.PinSHA256 == "" {
+		log.Printf("WARNING: No certificate pin, any server certificate will be accepted")
+	}
+
+	taskingKey, err := signing.ParsePublicKey(cfg.TaskPublicKey)
+	if err != nil {
+		log.Fatalf("invalid task public key: %v", err)
+	}
 
 	// Create our Agent instance
-	newAgent := agent.NewAgent(serverAddr)
+	newAgent, err := agent.NewAgent(serverAddr, agent.TransportConfig{
+		Protocol:           cfg.Protocol,
+		PinSHA256:          cfg.PinSHA256,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		ClientCertFile:     cfg.ClientCert,
+		ClientKeyFile:      cfg.ClientKey,
+	}, taskingKey)
+	if err != nil {
+		log.Fatalf("creating agent: %v", err)
+	}
 
 	// Create context for cancellation
 	ctx, cancel := context.WithCancel(context.Background())
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"workshop3_dev/internals/audit"
+)
+
+func main() {
+
+	logPath := flag.String("log", "./data/audit.log", "path to the audit log to verify")
+	expectHead := flag.String("expect-head", "", "checkpoint (seq:hash) printed by an earlier run and kept somewhere the server cannot write, verification fails unless the log still has that hash at that entry")
+	flag.Parse()
+
+	var checkpoints []audit.Checkpoint
+	if *expectHead != "" {
+		checkpoint, err := audit.ParseCheckpoint(*expectHead)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: -expect-head: %v\n", err)
+			os.Exit(2)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	// A log that has grown since the checkpoint passes, one rewritten or truncated before it does not
+	count, head, err := audit.Verify(*logPath, checkpoints...)
+	if err != nil {
+		fmt.Printf("TAMPERED: %s failed verification after %d good entries: %v\n", *logPath, count, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("OK: %d entries verified\n", count)
+	fmt.Printf("Checkpoint: %s\n", audit.Checkpoint{Seq: count, Hash: head})
+	if *expectHead == "" {
+		fmt.Println("Record the checkpoint outside the server and pass it back with -expect-head to detect a rewritten log")
+	}
+}
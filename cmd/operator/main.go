@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"workshop3_dev/internals/operator"
+
+	"golang.org/x/term"
+)
+
+func main() {
+
+	serverURL := flag.String("server", "http://127.0.0.1:8080", "control API base URL")
+	token := flag.String("token", os.Getenv("OPERATOR_TOKEN"), "API token (defaults to $OPERATOR_TOKEN)")
+	caFile := flag.String("ca", "", "CA certificate that signed the control API certificate")
+	certFile := flag.String("cert", "", "operator client certificate for mutual TLS")
+	keyFile := flag.String("key", "", "operator client key for mutual TLS")
+	flag.Parse()
+
+	client, err := operator.NewClient(operator.ClientConfig{
+		BaseURL:    *serverURL,
+		Token:      *token,
+		CAFile:     *caFile,
+		ClientCert: *certFile,
+		ClientKey:  *keyFile,
+	})
+	if err != nil {
+		log.Fatalf("creating client: %v", err)
+	}
+
+	// Raw mode gives us line editing, history and tab completion, piped input is read line by line
+	fd := int(os.Stdin.Fd())
+	interactive := term.IsTerminal(fd)
+	if interactive {
+		oldState, err := term.MakeRaw(fd)
+		if err != nil {
+			log.Fatalf("switching terminal to raw mode: %v", err)
+		}
+		defer term.Restore(fd, oldState)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	shell := operator.NewShell(client, os.Stdin, os.Stdout, interactive)
+
+	if err := shell.Run(ctx); err != nil {
+		log.Printf("shell: %v", err)
+	}
+}
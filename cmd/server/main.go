@@ -1,29 +1,172 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"workshop3_dev/internals/audit"
+	"workshop3_dev/internals/config"
 	"workshop3_dev/internals/control"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/payloads"
+	"workshop3_dev/internals/pki"
 	"workshop3_dev/internals/server"
+	"workshop3_dev/internals/signing"
+	"workshop3_dev/internals/storage"
+	"workshop3_dev/internals/webhook"
 )
 
 func main() {
 
-	serverInterface := "0.0.0.0:8443"
+	configPath := flag.String("config", "", "path to the server YAML config (defaults are used if omitted)")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration, print it and exit")
+	listenAddr := flag.String("listen", "", "override listener.address")
+	controlAddr := flag.String("control", "", "override control.address")
+	dataDir := flag.String("data-dir", "", "override data_dir")
+	flag.Parse()
 
-	// Load our control API
-	control.StartControlAPI()
+	cfg, err := config.LoadServer(*configPath)
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+
+	// Flags win over the config file
+	if *listenAddr != "" {
+		cfg.Listener.Address = *listenAddr
+	}
+	if *controlAddr != "" {
+		cfg.Control.Address = *controlAddr
+	}
+	if *dataDir != "" {
+		cfg.DataDir = *dataDir
+	}
+
+	if err := cfg.Validate(); err != nil {
+		if *checkConfig {
+			fmt.Printf("Configuration is invalid:\n%v\n", err)
+			os.Exit(1)
+		}
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	if *checkConfig {
+		fmt.Printf("Configuration OK\n\n%s", config.Dump(cfg))
+		return
+	}
+
+	// Open the datastore so agents, queues and job history survive a restart
+	if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+		log.Fatalf("creating data directory: %v", err)
+	}
+	store, err := storage.OpenBolt(filepath.Join(cfg.DataDir, "server.db"))
+	if err != nil {
+		log.Fatalf("opening datastore: %v", err)
+	}
+	defer store.Close()
+
+	if err := control.UseStore(store); err != nil {
+		log.Fatalf("restoring state: %v", err)
+	}
+
+	// Every operator and agent action is recorded in the hash-chained audit log
+	auditLog, err := audit.Open(filepath.Join(cfg.DataDir, "audit.log"))
+	if err != nil {
+		log.Fatalf("opening audit log: %v", err)
+	}
+	defer auditLog.Close()
+	audit.SetDefault(auditLog)
+
+	// The local CA issues listener certificates, creating itself on first start
+	authority, err := pki.Load(cfg.PKI.Dir, cfg.PKI.Hosts)
+	if err != nil {
+		log.Fatalf("loading PKI: %v", err)
+	}
+	control.PKI = authority
+	log.Printf("CA fingerprint (SHA-256): %s", authority.Info().CAFingerprint)
 
-	newServer := server.NewServer(serverInterface)
+	// The engagement key signs every job, agents refuse anything it did not sign
+	signer, err := signing.LoadOrCreateSigner(filepath.Join(cfg.DataDir, "engagement.key"), cfg.TaskTTL)
+	if err != nil {
+		log.Fatalf("loading engagement key: %v", err)
+	}
+	control.Signer = signer
+	log.Printf("Engagement public key: %s", signer.PublicKey())
+
+	// Operators upload DLLs to the payload library and reference them from tasks
+	library, err := payloads.Open(filepath.Join(cfg.DataDir, "payloads"), store)
+	if err != nil {
+		log.Fatalf("opening payload library: %v", err)
+	}
+	control.Payloads = library
+	if err := control.SetPayloadRoots(cfg.PayloadRoots); err != nil {
+		log.Fatalf("loading payload roots: %v", err)
+	}
+
+	// The listener manager lets operators add listeners at runtime through the control API
+	listeners := server.NewManager(authority)
+	control.Listeners = listeners
+
+	// Load our control API, only reachable locally unless explicitly configured otherwise
+	controlConfig := control.ControlAPIConfig{
+		Address:       cfg.Control.Address,
+		OperatorsFile: cfg.Control.OperatorsFile,
+		DataDir:       cfg.DataDir,
+		TLSCert:       cfg.Control.TLSCert,
+		TLSKey:        cfg.Control.TLSKey,
+		ClientCA:      cfg.Control.ClientCA,
+	}
+	if err := control.StartControlAPI(controlConfig); err != nil {
+		log.Fatalf("control API error: %v", err)
+	}
+
+	// Mark jobs as timed out if their agent never reports back
+	control.StartJobTimeoutWatcher(cfg.JobTimeout)
+
+	// Let operators know when agents stop checking in
+	control.StartAgentStatusWatcher()
 
-	// Start server in goroutine
-	go func() {
-		log.Printf("Starting  server on %s", serverInterface)
-		if err := newServer.Start(); err != nil {
-			log.Fatalf("server error: %v", err)
+	// Check-ins only update memory, write them to the datastore every so often
+	control.StartCheckInFlusher()
+
+	// Queue recurring runs on their cron schedule and expire jobs whose dispatch window has closed
+	control.StartScheduler()
+
+	// Forward events to the configured webhook targets
+	targets := make([]webhook.Target, 0, len(cfg.Webhooks))
+	for _, wh := range cfg.Webhooks {
+		target := webhook.Target{
+			Name:     wh.Name,
+			URL:      wh.URL,
+			Secret:   wh.Secret,
+			Commands: wh.Commands,
+			Statuses: wh.Statuses,
+		}
+		for _, event := range wh.Events {
+			target.Events = append(target.Events, models.EventType(event))
 		}
-	}()
+		targets = append(targets, target)
+	}
+	webhooks := webhook.NewDispatcher(targets)
+	webhooks.Start()
+
+	// Create and start the listener from the config file
+	defaultListener, err := listeners.Create(models.ListenerConfig{
+		Name:              "default",
+		Address:           cfg.Listener.Address,
+		Protocol:          cfg.Listener.Protocol,
+		CertFile:          cfg.Listener.CertFile,
+		KeyFile:           cfg.Listener.KeyFile,
+		RequireClientCert: cfg.Listener.RequireClientCert,
+	})
+	if err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+	if _, err := listeners.Start(defaultListener.ID); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
 
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
@@ -33,8 +176,10 @@ func main() {
 	// Graceful shutdown
 	log.Println("Shutting down server...")
 
-	if err := newServer.Stop(); err != nil {
-		log.Printf("Error stopping server: %v", err)
-	}
+	listeners.StopAll()
+
+	webhooks.Stop()
 
+	// Write the last check-ins before the datastore is closed
+	control.FlushCheckIns()
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/webhook"
+)
+
+// A local stand-in for a chat or ticketing bridge, it checks and prints every webhook delivery it receives
+func main() {
+
+	listenAddr := flag.String("listen", "127.0.0.1:9000", "address to receive webhooks on")
+	secret := flag.String("secret", "", "shared secret configured for the webhook target")
+	failFirst := flag.Int("fail", 0, "answer the first N deliveries with 503 to exercise retries")
+	flag.Parse()
+
+	if *secret == "" {
+		log.Fatal("-secret is required")
+	}
+
+	var received atomic.Int64
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "reading body", http.StatusBadRequest)
+			return
+		}
+
+		n := received.Add(1)
+		if n <= int64(*failFirst) {
+			log.Printf("#%d delivery %s: failing on purpose", n, r.Header.Get(webhook.DeliveryHeader))
+			http.Error(w, "failing on purpose", http.StatusServiceUnavailable)
+			return
+		}
+
+		err = webhook.Verify(*secret, r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, time.Now(), 5*time.Minute)
+		if err != nil {
+			log.Printf("#%d REJECTED delivery %s: %v", n, r.Header.Get(webhook.DeliveryHeader), err)
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		var event models.Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("#%d %s agent=%s job=%s command=%s status=%s %s",
+			n, event.Type, event.AgentID, event.JobID, event.Command, event.Status, event.Detail)
+	})
+
+	log.Printf("Receiving webhooks on http://%s", *listenAddr)
+	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+}
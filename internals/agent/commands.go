@@ -2,9 +2,12 @@ package agent
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"log"
+	"time"
 	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/replay"
+	"workshop3_dev/internals/signing"
 )
 
 type OrchestratorFunc func(agent *Agent, job *models.ServerResponse) models.AgentTaskResult
@@ -16,16 +19,37 @@ func (agent *Agent) ExecuteTask(job *models.ServerResponse) {
 
 	orchestrator, found := agent.commandOrchestrators[job.Command]
 
-	if found {
+	// Refuse anything not signed by our team server for us, and report the refusal back
+	now := time.Now()
+	if err := signing.Verify(agent.taskingKey, job, agent.agentID, now); err != nil {
+		log.Printf("|❗ERR AGENT TASK| Refusing Task ID %s: %v", job.JobID, err)
+		result = models.AgentTaskResult{
+			JobID:   job.JobID,
+			Success: false,
+			Error:   fmt.Sprintf("task rejected: %v", err),
+		}
+	} else if err := agent.executedJobs.Remember(job.JobID, job.ExpiresAt, now); err != nil {
+		// A job is only ever run once, the signature alone would let a replay run it again until it expires
+		log.Printf("|❗ERR AGENT TASK| Ignoring replayed Task ID %s: %v", job.JobID, err)
+		return
+	} else if found {
 		result = orchestrator(agent, job)
 	} else {
 		log.Printf("|WARN AGENT TASK| Received unknown command: '%s' (ID: %s)", job.Command, job.JobID)
 		result = models.AgentTaskResult{
 			JobID:   job.JobID,
 			Success: false,
-			Error:   errors.New("command not found"),
+			Error:   "command not found",
 		}
 	}
+
+	// Tag the result with our identity so the server knows who ran the job
+	result.AgentID = agent.agentID
+
+	// Make this submission unique so the server can spot replays of it
+	result.Nonce = replay.NewNonce()
+	result.IssuedAt = time.Now().UTC()
+
 	// Now marshall the result before sending it back
 	resultBytes, err := json.Marshal(result)
 	if err != nil {
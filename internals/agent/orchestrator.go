@@ -3,7 +3,6 @@ package agent
 import (
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"workshop3_dev/internals/models"
@@ -23,7 +22,7 @@ func (agent *Agent) orchestrateShellcode(job *models.ServerResponse) models.Agen
 		return models.AgentTaskResult{
 			JobID:   job.JobID,
 			Success: false,
-			Error:   errors.New("failed to unmarshal ShellcodeArgs"),
+			Error:   "failed to unmarshal ShellcodeArgs",
 		}
 	}
 	log.Printf("|✅ SHELLCODE ORCHESTRATOR| Task ID: %s. Executing Shellcode, Export Function: %s, ShellcodeLen(b64)=%d\n",
@@ -35,7 +34,7 @@ func (agent *Agent) orchestrateShellcode(job *models.ServerResponse) models.Agen
 		return models.AgentTaskResult{
 			JobID:   job.JobID,
 			Success: false,
-			Error:   errors.New("ShellcodeBase64 cannot be empty"),
+			Error:   "ShellcodeBase64 cannot be empty",
 		}
 	}
 
@@ -44,7 +43,7 @@ func (agent *Agent) orchestrateShellcode(job *models.ServerResponse) models.Agen
 		return models.AgentTaskResult{
 			JobID:   job.JobID,
 			Success: false,
-			Error:   errors.New("ExportName must be specified for DLL execution"),
+			Error:   "ExportName must be specified for DLL execution",
 		}
 	}
 
@@ -55,7 +54,7 @@ func (agent *Agent) orchestrateShellcode(job *models.ServerResponse) models.Agen
 		return models.AgentTaskResult{
 			JobID:   job.JobID,
 			Success: false,
-			Error:   errors.New("Failed to decode shellcode"),
+			Error:   "Failed to decode shellcode",
 		}
 	}
 
@@ -76,7 +75,7 @@ func (agent *Agent) orchestrateShellcode(job *models.ServerResponse) models.Agen
 		loaderError := fmt.Sprintf("|❗ERR SHELLCODE ORCHESTRATOR| Loader execution error for TaskID %s: %v. Loader Message: %s",
 			job.JobID, err, shellcodeResult.Message)
 		log.Printf(loaderError)
-		finalResult.Error = errors.New(loaderError)
+		finalResult.Error = loaderError
 		finalResult.Success = false
 
 	} else {
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"log"
 	"math/rand"
 	"time"
@@ -18,7 +19,24 @@ func RunLoop(agent *Agent, ctx context.Context, delay time.Duration, jitter int)
 		default:
 		}
 
+		// Register on first contact, or again if the server has forgotten us
+		if agent.ID() == "" {
+			if err := agent.Register(ctx, delay, jitter); err != nil {
+				log.Printf("Error registering with server: %v", err)
+				time.Sleep(delay)
+				continue
+			}
+		}
+
 		response, err := agent.Send(ctx)
+		if errors.Is(err, ErrNotRegistered) {
+			log.Printf("Server does not recognise agent %s, registering again", agent.ID())
+			if err := agent.Register(ctx, delay, jitter); err != nil {
+				log.Printf("Error registering with server: %v", err)
+			}
+			time.Sleep(delay)
+			continue
+		}
 		if err != nil {
 			log.Printf("Error sending request: %v", err)
 			// Don't exit - just sleep and try again
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog records n entries in a fresh log and returns its path and the checkpoint of every entry, by sequence
+func writeLog(t *testing.T, n int) (string, []Checkpoint) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	checkpoints := []Checkpoint{{Seq: 0, Hash: genesisHash}}
+	for i := 0; i < n; i++ {
+		if err := l.Record(Entry{Action: "job_queued", Operator: "alice", JobID: fmt.Sprintf("job_%06d", i+1), Outcome: "queued"}); err != nil {
+			t.Fatal(err)
+		}
+		checkpoints = append(checkpoints, Checkpoint{Seq: l.lastSeq, Hash: l.lastHash})
+	}
+	return path, checkpoints
+}
+
+// rewriteLines applies edit to the lines of the log at path
+func rewriteLines(t *testing.T, path string, edit func([][]byte) [][]byte) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	lines = edit(lines)
+	if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       func(t *testing.T, path string) // Changes the log after its 5 entries are written
+		checkpoint int                             // Entry whose checkpoint is passed to Verify, 0 for none
+		wantErr    string                          // Part of the error, empty when the log verifies
+		wantCount  uint64
+	}{
+		{
+			name:      "untouched",
+			edit:      func(t *testing.T, path string) {},
+			wantCount: 5,
+		},
+		{
+			name:       "untouched at its head",
+			edit:       func(t *testing.T, path string) {},
+			checkpoint: 5,
+			wantCount:  5,
+		},
+		{
+			name: "edited entry",
+			edit: func(t *testing.T, path string) {
+				rewriteLines(t, path, func(lines [][]byte) [][]byte {
+					lines[2] = bytes.Replace(lines[2], []byte(`"alice"`), []byte(`"mallory"`), 1)
+					return lines
+				})
+			},
+			wantErr:   "entry 3: contents have been modified",
+			wantCount: 2,
+		},
+		{
+			name: "deleted entry",
+			edit: func(t *testing.T, path string) {
+				rewriteLines(t, path, func(lines [][]byte) [][]byte {
+					return append(lines[:1], lines[2:]...)
+				})
+			},
+			wantErr:   "expected sequence 2, found 3",
+			wantCount: 1,
+		},
+		{
+			name: "truncated",
+			edit: func(t *testing.T, path string) {
+				rewriteLines(t, path, func(lines [][]byte) [][]byte { return lines[:3] })
+			},
+			checkpoint: 5,
+			wantErr:    "log ends at entry 3, before checkpoint 5:",
+			wantCount:  3,
+		},
+		{
+			name: "truncated without a checkpoint",
+			edit: func(t *testing.T, path string) {
+				rewriteLines(t, path, func(lines [][]byte) [][]byte { return lines[:3] })
+			},
+			wantCount: 3,
+		},
+		{
+			name: "grew",
+			edit: func(t *testing.T, path string) {
+				l, err := Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer l.Close()
+				for i := 0; i < 3; i++ {
+					if err := l.Record(Entry{Action: "agent_registered", Outcome: "registered"}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			checkpoint: 5,
+			wantCount:  8,
+		},
+		{
+			name: "rewritten from scratch",
+			edit: func(t *testing.T, path string) {
+				os.Remove(path)
+				l, err := Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer l.Close()
+				for i := 0; i < 6; i++ {
+					if err := l.Record(Entry{Action: "job_queued", Operator: "mallory", Outcome: "queued"}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			checkpoint: 5,
+			wantErr:    "entry 5: hash",
+			wantCount:  4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, checkpoints := writeLog(t, 5)
+			tt.edit(t, path)
+
+			var expected []Checkpoint
+			if tt.checkpoint != 0 {
+				expected = append(expected, checkpoints[tt.checkpoint])
+			}
+
+			count, _, err := Verify(path, expected...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Verify failed: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("Verify passed, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("Verify error %q does not contain %q", err, tt.wantErr)
+			}
+			if count != tt.wantCount {
+				t.Errorf("Verify counted %d good entries, want %d", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+
+	checkpoint, err := ParseCheckpoint(" 12:" + strings.ToUpper(hash) + "\n")
+	if err != nil {
+		t.Fatalf("ParseCheckpoint failed: %v", err)
+	}
+	if checkpoint != (Checkpoint{Seq: 12, Hash: hash}) {
+		t.Errorf("ParseCheckpoint = %+v, want seq 12 and the lower case hash", checkpoint)
+	}
+	if got, want := checkpoint.String(), "12:"+hash; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	for _, s := range []string{"", hash, "0:" + hash, "x:" + hash, "12:abc", "-1:" + hash} {
+		if _, err := ParseCheckpoint(s); err == nil {
+			t.Errorf("ParseCheckpoint(%q) accepted an invalid checkpoint", s)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AgentConfig holds every setting of the agent
+type AgentConfig struct {
+	ServerAddr string        `yaml:"server_addr"`
+	Protocol   string        `yaml:"protocol"` // Must match the listener, "https" or "http"
+	Delay      time.Duration `yaml:"delay"`
+	Jitter     int           `yaml:"jitter"` // Percentage of delay
+
+	// Values baked in at build time always win over these
+	TaskPublicKey      string `yaml:"task_public_key,omitempty"` // Base64 engagement key, see GET /signing-key
+	PinSHA256          string `yaml:"pin_sha256,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+	ClientCert         string `yaml:"client_cert,omitempty"`
+	ClientKey          string `yaml:"client_key,omitempty"`
+}
+
+// DefaultAgent returns the settings used for anything not in the config file
+func DefaultAgent() AgentConfig {
+	return AgentConfig{
+		ServerAddr: "192.168.2.11:8443",
+		Protocol:   "https",
+		Delay:      5 * time.Second,
+		Jitter:     50,
+	}
+}
+
+// LoadAgent reads the agent config at path on top of the defaults, an empty path just returns the defaults
+func LoadAgent(path string) (AgentConfig, error) {
+	cfg := DefaultAgent()
+
+	if path == "" {
+		return cfg, nil
+	}
+
+	if err := decodeFile(path, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the agent config for mistakes, reporting all of them at once
+func (cfg AgentConfig) Validate() error {
+	var errs []error
+
+	if err := validateAddress("server_addr", cfg.ServerAddr); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Protocol != "https" && cfg.Protocol != "http" {
+		errs = append(errs, fmt.Errorf("protocol must be https or http, got %q", cfg.Protocol))
+	}
+	if cfg.TaskPublicKey == "" {
+		errs = append(errs, errors.New("task_public_key is required, set it or bake it in at build time"))
+	}
+	if cfg.Protocol == "https" && cfg.PinSHA256 == "" && !cfg.InsecureSkipVerify {
+		errs = append(errs, errors.New("pin_sha256 is required for https, set it or bake it in at build time"))
+	}
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		errs = append(errs, errors.New("client_cert and client_key must be set together"))
+	}
+	if cfg.ClientCert != "" {
+		if err := validateFile("client_cert", cfg.ClientCert); err != nil {
+			errs = append(errs, err)
+		}
+		if err := validateFile("client_key", cfg.ClientKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.Delay <= 0 {
+		errs = append(errs, fmt.Errorf("delay must be positive, got %v", cfg.Delay))
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 100 {
+		errs = append(errs, fmt.Errorf("jitter must be between 0 and 100, got %d", cfg.Jitter))
+	}
+
+	return errors.Join(errs...)
+}
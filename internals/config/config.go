@@ -0,0 +1,77 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"os"
+	"strconv"
+)
+
+// decodeFile strictly decodes the YAML file at path into out, leaving defaults alone for missing keys
+func decodeFile(path string, out any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true) // Catch typos instead of silently ignoring them
+
+	if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Dump renders a configuration as YAML, used by -check-config to show the effective settings
+func Dump(cfg any) string {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Sprintf("error rendering config: %v", err)
+	}
+	return string(data)
+}
+
+// validateAddress checks addr is a host:port pair with a usable port
+func validateAddress(name string, addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s: invalid port %q", name, port)
+	}
+
+	return nil
+}
+
+// validateDir checks a configured directory exists
+func validateDir(name string, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: %s is not a directory", name, path)
+	}
+	return nil
+}
+
+// validateFile checks a configured file exists and is not a directory
+func validateFile(name string, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: %s is a directory", name, path)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+	"workshop3_dev/internals/models"
+)
+
+// ServerConfig holds every setting of the team server
+type ServerConfig struct {
+	Listener     ListenerConfig  `yaml:"listener"`
+	Control      ControlConfig   `yaml:"control"`
+	PKI          PKIConfig       `yaml:"pki"`
+	DataDir      string          `yaml:"data_dir"`
+	JobTimeout   time.Duration   `yaml:"job_timeout"`
+	TaskTTL      time.Duration   `yaml:"task_ttl"`                // How long a signed job stays valid after dispatch
+	PayloadRoots []string        `yaml:"payload_roots,omitempty"` // Directories file_path may read from, empty allows library payloads only
+	Webhooks     []WebhookConfig `yaml:"webhooks,omitempty"`
+}
+
+// ListenerConfig holds the settings of the listener agents connect to
+type ListenerConfig struct {
+	Address  string `yaml:"address"`
+	Protocol string `yaml:"protocol"`            // "https", or "http" for isolated labs only
+	CertFile string `yaml:"cert_file,omitempty"` // Leave both empty to use a certificate from the local CA
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// Only accept agents presenting a client certificate issued by the local CA
+	RequireClientCert bool `yaml:"require_client_cert,omitempty"`
+}
+
+// PKIConfig holds the settings of the local CA that issues listener certificates
+type PKIConfig struct {
+	Dir   string   `yaml:"dir"`
+	Hosts []string `yaml:"hosts,omitempty"` // Extra names and IPs for the listener certificate
+}
+
+// ControlConfig holds the settings of the operator-facing control API
+type ControlConfig struct {
+	Address       string `yaml:"address"`
+	OperatorsFile string `yaml:"operators_file"`
+	TLSCert       string `yaml:"tls_cert,omitempty"`
+	TLSKey        string `yaml:"tls_key,omitempty"`
+	ClientCA      string `yaml:"client_ca,omitempty"`
+}
+
+// WebhookConfig describes a target that is sent a signed JSON payload for matching events
+type WebhookConfig struct {
+	Name     string   `yaml:"name"`
+	URL      string   `yaml:"url"`
+	Secret   string   `yaml:"secret"`             // HMAC-SHA256 key the receiver checks the signature with
+	Events   []string `yaml:"events"`             // Event types to send, see models.EventType
+	Commands []string `yaml:"commands,omitempty"` // Only send job events for these commands
+	Statuses []string `yaml:"statuses,omitempty"` // Only send job events with these statuses, e.g. failed
+}
+
+// MarshalYAML keeps the webhook secret out of -check-config output
+func (wh WebhookConfig) MarshalYAML() (any, error) {
+	type plain WebhookConfig
+	redacted := plain(wh)
+	if redacted.Secret != "" {
+		redacted.Secret = "REDACTED"
+	}
+	return redacted, nil
+}
+
+// webhookEvents are the event types a webhook can subscribe to
+var webhookEvents = []models.EventType{
+	models.EventAgentRegistered,
+	models.EventAgentCheckIn,
+	models.EventAgentStale,
+	models.EventAgentDead,
+	models.EventJobQueued,
+	models.EventJobDispatched,
+	models.EventResultReceived,
+	models.EventJobTimedOut,
+	models.EventJobCancelled,
+	models.EventJobExpired,
+	models.EventJobUpdated,
+	models.EventBroadcastFinished,
+	models.EventAlert,
+}
+
+// DefaultServer returns the settings used for anything not in the config file
+func DefaultServer() ServerConfig {
+	return ServerConfig{
+		Listener: ListenerConfig{
+			Address:  "0.0.0.0:8443",
+			Protocol: "https",
+		},
+		Control: ControlConfig{
+			Address:       "127.0.0.1:8080",
+			OperatorsFile: "./operators.yaml",
+		},
+		PKI: PKIConfig{
+			Dir: "./certs",
+		},
+		DataDir:    "./data",
+		JobTimeout: 5 * time.Minute,
+		TaskTTL:    10 * time.Minute,
+	}
+}
+
+// LoadServer reads the server config at path on top of the defaults, an empty path just returns the defaults
+func LoadServer(path string) (ServerConfig, error) {
+	cfg := DefaultServer()
+
+	if path == "" {
+		return cfg, nil
+	}
+
+	if err := decodeFile(path, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the server config for mistakes, reporting all of them at once
+func (cfg ServerConfig) Validate() error {
+	var errs []error
+
+	if err := validateAddress("listener.address", cfg.Listener.Address); err != nil {
+		errs = append(errs, err)
+	}
+	switch cfg.Listener.Protocol {
+	case "https":
+		if (cfg.Listener.CertFile == "") != (cfg.Listener.KeyFile == "") {
+			errs = append(errs, errors.New("listener.cert_file and listener.key_file must be set together"))
+		} else if cfg.Listener.CertFile != "" {
+			if err := validateFile("listener.cert_file", cfg.Listener.CertFile); err != nil {
+				errs = append(errs, err)
+			}
+			if err := validateFile("listener.key_file", cfg.Listener.KeyFile); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	case "http":
+		if cfg.Listener.RequireClientCert {
+			errs = append(errs, errors.New("listener.require_client_cert needs the https protocol"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("listener.protocol must be https or http, got %q", cfg.Listener.Protocol))
+	}
+
+	if err := validateAddress("control.address", cfg.Control.Address); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Control.OperatorsFile == "" {
+		errs = append(errs, errors.New("control.operators_file is required"))
+	}
+	if (cfg.Control.TLSCert == "") != (cfg.Control.TLSKey == "") {
+		errs = append(errs, errors.New("control.tls_cert and control.tls_key must be set together"))
+	}
+	if cfg.Control.TLSCert != "" {
+		if err := validateFile("control.tls_cert", cfg.Control.TLSCert); err != nil {
+			errs = append(errs, err)
+		}
+		if err := validateFile("control.tls_key", cfg.Control.TLSKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.Control.ClientCA != "" {
+		if cfg.Control.TLSCert == "" {
+			errs = append(errs, errors.New("control.client_ca requires control.tls_cert"))
+		}
+		if err := validateFile("control.client_ca", cfg.Control.ClientCA); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if cfg.PKI.Dir == "" {
+		errs = append(errs, errors.New("pki.dir is required"))
+	}
+
+	if cfg.DataDir == "" {
+		errs = append(errs, errors.New("data_dir is required"))
+	}
+	if cfg.JobTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("job_timeout must be positive, got %v", cfg.JobTimeout))
+	}
+
+	if cfg.TaskTTL <= 0 {
+		errs = append(errs, fmt.Errorf("task_ttl must be positive, got %v", cfg.TaskTTL))
+	}
+
+	for i, root := range cfg.PayloadRoots {
+		if err := validateDir(fmt.Sprintf("payload_roots[%d]", i), root); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, wh := range cfg.Webhooks {
+		field := fmt.Sprintf("webhooks[%d]", i)
+
+		if wh.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name is required", field))
+		} else if names[wh.Name] {
+			errs = append(errs, fmt.Errorf("%s.name %q is used more than once", field, wh.Name))
+		}
+		names[wh.Name] = true
+
+		if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.url must be an http or https URL, got %q", field, wh.URL))
+		}
+		if wh.Secret == "" {
+			errs = append(errs, fmt.Errorf("%s.secret is required", field))
+		}
+		if len(wh.Events) == 0 {
+			errs = append(errs, fmt.Errorf("%s.events needs at least one event type", field))
+		}
+		for _, event := range wh.Events {
+			if !slices.Contains(webhookEvents, models.EventType(event)) {
+				errs = append(errs, fmt.Errorf("%s.events: unknown event type %q", field, event))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,271 @@
+package control
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"workshop3_dev/internals/events"
+	"workshop3_dev/internals/models"
+)
+
+const (
+	staleAfterMissed    = 3  // Missed check-ins before an agent is stale
+	deadAfterMissed     = 10 // Missed check-ins before an agent is dead
+	minCheckInInterval  = time.Second
+	statusCheckInterval = 10 * time.Second
+	lastSeenFlushEvery  = 30 * time.Second // How often check-ins are written to storage, they are only kept in memory between
+)
+
+// AgentRegistry keeps track of every agent that has registered with the server
+type AgentRegistry struct {
+	agents map[string]*models.Agent
+	keys   map[string][]byte // Session keys agreed at registration, never handed out through the API
+	dirty  map[string]bool   // Agents whose last check-in has not been written to storage yet
+	mu     sync.RWMutex
+}
+
+// Agents is the global agent registry
+var Agents = AgentRegistry{
+	agents: make(map[string]*models.Agent),
+	keys:   make(map[string][]byte),
+	dirty:  make(map[string]bool),
+}
+
+// Register adds an agent to the registry with the session key it just agreed on.
+// A previously assigned ID is only re-adopted if the server has no record of it,
+// otherwise anyone knowing an agent's ID could re-key its session and read its tasks.
+func (ar *AgentRegistry) Register(req models.RegisterRequest, remoteAddr string, sessionKey []byte) models.Agent {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	now := time.Now()
+
+	id := req.AgentID
+	if _, exists := ar.agents[id]; exists || id == "" {
+		id = newAgentID()
+	}
+	agent := &models.Agent{
+		ID:        id,
+		FirstSeen: now,
+	}
+	ar.agents[id] = agent
+	ar.keys[id] = sessionKey
+
+	agent.Hostname = req.Hostname
+	agent.Username = req.Username
+	agent.OS = req.OS
+	agent.Arch = req.Arch
+	agent.PID = req.PID
+	agent.SleepSeconds = req.SleepSeconds
+	agent.Jitter = req.Jitter
+	agent.RemoteAddr = remoteAddr
+	agent.LastSeen = now
+
+	log.Printf("REGISTERED: Agent %s (%s@%s, %s/%s)", agent.ID, agent.Username, agent.Hostname, agent.OS, agent.Arch)
+
+	persistAgent(*agent)
+	persistSessionKey(id, sessionKey)
+	delete(ar.dirty, id)
+
+	return *agent
+}
+
+// SessionKey returns the key used to seal messages to and from an agent
+func (ar *AgentRegistry) SessionKey(agentID string) ([]byte, bool) {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	key, exists := ar.keys[agentID]
+	return key, exists
+}
+
+// CheckIn records that a known agent has contacted the server, returns false if the agent is unknown.
+// The check-in is only kept in memory until the next FlushCheckIns.
+func (ar *AgentRegistry) CheckIn(agentID string, remoteAddr string) (models.Agent, bool) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	agent, exists := ar.agents[agentID]
+	if !exists {
+		return models.Agent{}, false
+	}
+
+	agent.RemoteAddr = remoteAddr
+	agent.LastSeen = time.Now()
+	ar.dirty[agentID] = true
+
+	return *agent, true
+}
+
+// FlushCheckIns writes every agent that has checked in since the last flush to storage
+func (ar *AgentRegistry) FlushCheckIns() {
+	ar.mu.Lock()
+	agents := make([]models.Agent, 0, len(ar.dirty))
+	for agentID := range ar.dirty {
+		agents = append(agents, *ar.agents[agentID])
+	}
+	clear(ar.dirty)
+	ar.mu.Unlock()
+
+	for _, agent := range agents {
+		persistAgent(agent)
+	}
+}
+
+// FlushCheckIns writes what check-ins changed since the last flush to storage: agents' last check-ins and the
+// check-in counts of recurring jobs
+func FlushCheckIns() {
+	Agents.FlushCheckIns()
+	Jobs.flushCheckInCounts()
+}
+
+// StartCheckInFlusher periodically writes check-ins to storage, main flushes once more on shutdown
+func StartCheckInFlusher() {
+	go func() {
+		ticker := time.NewTicker(lastSeenFlushEvery)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			FlushCheckIns()
+		}
+	}()
+}
+
+// Get returns a copy of a single agent
+func (ar *AgentRegistry) Get(agentID string) (models.Agent, bool) {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	agent, exists := ar.agents[agentID]
+	if !exists {
+		return models.Agent{}, false
+	}
+
+	return *agent, true
+}
+
+// List returns a copy of all registered agents
+func (ar *AgentRegistry) List() []models.Agent {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	agents := make([]models.Agent, 0, len(ar.agents))
+	for _, agent := range ar.agents {
+		agents = append(agents, *agent)
+	}
+
+	return agents
+}
+
+// SetTags replaces an agent's tags, returns false if the agent is unknown
+func (ar *AgentRegistry) SetTags(agentID string, tags []string) (models.Agent, bool) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	agent, exists := ar.agents[agentID]
+	if !exists {
+		return models.Agent{}, false
+	}
+
+	agent.Tags = tags
+	persistAgent(*agent)
+	delete(ar.dirty, agentID)
+
+	return *agent, true
+}
+
+// WithTag returns a copy of every agent carrying the tag, in the order they first registered
+func (ar *AgentRegistry) WithTag(tag string) []models.Agent {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+
+	agents := make([]models.Agent, 0)
+	for _, agent := range ar.agents {
+		if slices.Contains(agent.Tags, tag) {
+			agents = append(agents, *agent)
+		}
+	}
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].FirstSeen.Before(agents[j].FirstSeen)
+	})
+
+	return agents
+}
+
+// Status works out whether an agent has missed too many check-ins, based on its own sleep and jitter
+func Status(agent models.Agent, now time.Time) models.AgentStatus {
+	// The longest an agent should ever sleep between two check-ins
+	interval := time.Duration(agent.SleepSeconds * float64(time.Second) * (1 + float64(agent.Jitter)/100))
+	if interval < minCheckInInterval {
+		interval = minCheckInInterval
+	}
+
+	silence := now.Sub(agent.LastSeen)
+	switch {
+	case silence > interval*deadAfterMissed:
+		return models.AgentDead
+	case silence > interval*staleAfterMissed:
+		return models.AgentStale
+	default:
+		return models.AgentActive
+	}
+}
+
+// StartAgentStatusWatcher periodically publishes an event whenever an agent goes stale or dead
+func StartAgentStatusWatcher() {
+	go func() {
+		ticker := time.NewTicker(statusCheckInterval)
+		defer ticker.Stop()
+
+		// The first pass only records where every agent stands, so a restart doesn't replay old news
+		last := make(map[string]models.AgentStatus)
+		for _, agent := range Agents.List() {
+			last[agent.ID] = Status(agent, time.Now())
+		}
+
+		for range ticker.C {
+			now := time.Now()
+			for _, agent := range Agents.List() {
+				status := Status(agent, now)
+				if status == last[agent.ID] {
+					continue
+				}
+				last[agent.ID] = status
+
+				var eventType models.EventType
+				switch status {
+				case models.AgentStale:
+					eventType = models.EventAgentStale
+				case models.AgentDead:
+					eventType = models.EventAgentDead
+				default:
+					continue
+				}
+
+				log.Printf("Agent %s is now %s, last seen %s", agent.ID, status, agent.LastSeen.Format(time.RFC3339))
+				events.Publish(models.Event{
+					Type:    eventType,
+					AgentID: agent.ID,
+					Status:  string(status),
+					Detail:  "last seen " + agent.LastSeen.UTC().Format(time.RFC3339),
+				})
+			}
+		}
+	}()
+}
+
+// newAgentID generates a random identifier for a newly registered agent
+func newAgentID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand should never fail, but fall back to a time-based ID rather than panic
+		return fmt.Sprintf("agent_%x", time.Now().UnixNano())
+	}
+	return "agent_" + hex.EncodeToString(b)
+}
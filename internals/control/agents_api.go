@@ -0,0 +1,136 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"workshop3_dev/internals/audit"
+	"workshop3_dev/internals/models"
+)
+
+// maxTagLength keeps tags short enough to type on an operator prompt
+const maxTagLength = 64
+
+// listAgentsHandler returns every known agent, optionally only those with the status or tag given in the query
+func listAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	status := models.AgentStatus(r.URL.Query().Get("status"))
+	tag := r.URL.Query().Get("tag")
+
+	switch status {
+	case "", models.AgentActive, models.AgentStale, models.AgentDead:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Unknown status: %s", status))
+		return
+	}
+
+	now := time.Now()
+	agents := Agents.List()
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].FirstSeen.Before(agents[j].FirstSeen)
+	})
+
+	infos := make([]models.AgentInfo, 0, len(agents))
+	for _, agent := range agents {
+		info := agentInfo(agent, now)
+		if status != "" && info.Status != status {
+			continue
+		}
+		if tag != "" && !slices.Contains(agent.Tags, tag) {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// getAgentHandler returns a single agent
+func getAgentHandler(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	agent, exists := Agents.Get(agentID)
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Unknown agent: %s", agentID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agentInfo(agent, time.Now()))
+}
+
+// setAgentTagsHandler replaces the tags of an agent, an empty list removes them all
+func setAgentTagsHandler(w http.ResponseWriter, r *http.Request) {
+	op, _ := OperatorFromContext(r.Context())
+	agentID := chi.URLParam(r, "id")
+
+	var req models.TagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Invalid request: %v", err))
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: %v", err))
+		return
+	}
+
+	agent, exists := Agents.SetTags(agentID, tags)
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Unknown agent: %s", agentID))
+		return
+	}
+
+	log.Printf("TAGGED: Agent %s with %v by %s", agent.ID, agent.Tags, op.Name)
+	audit.Record(audit.Entry{
+		Action:   "agent_tagged",
+		Operator: op.Name,
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		AgentID:  agent.ID,
+		Outcome:  strings.Join(agent.Tags, ","),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agentInfo(agent, time.Now()))
+}
+
+// normalizeTags checks every tag is a short word of letters, digits, '-', '_' or '.', and sorts them without duplicates
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag == "" || len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be between 1 and %d characters", maxTagLength)
+		}
+		for _, c := range tag {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				return nil, fmt.Errorf("invalid tag %q, only letters, digits, '-', '_' and '.' are allowed", tag)
+			}
+		}
+		normalized = append(normalized, tag)
+	}
+
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
+}
+
+// agentInfo adds the computed status and queue depth to an agent
+func agentInfo(agent models.Agent, now time.Time) models.AgentInfo {
+	return models.AgentInfo{
+		Agent:      agent,
+		Status:     Status(agent, now),
+		QueueDepth: AgentCommands.Depth(agent.ID),
+	}
+}
@@ -0,0 +1,100 @@
+package control
+
+import (
+	"log"
+	"sync"
+	"time"
+	"workshop3_dev/internals/audit"
+	"workshop3_dev/internals/events"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/replay"
+)
+
+const (
+	maxAlerts = 500 // Older alerts are dropped from memory, the audit log keeps all of them
+
+	// ReplayWindow is how far an agent's timestamp may drift from ours before a check-in or result is refused
+	ReplayWindow = 5 * time.Minute
+)
+
+// Alert kinds
+const (
+	AlertReplayed = "replayed_message"
+	AlertStale    = "stale_message"
+	AlertTampered = "tampered_message"
+)
+
+// AlertLog is an in-memory ring of the most recent alerts
+type AlertLog struct {
+	alerts []models.Alert
+	nextID uint64
+	mu     sync.RWMutex
+}
+
+// Alerts is the global alert stream
+var Alerts = AlertLog{
+	alerts: make([]models.Alert, 0, maxAlerts),
+}
+
+// ResultNonces remembers the nonces of results accepted within the replay window
+var ResultNonces = replay.NewCache()
+
+// CheckInNonces remembers the nonces of check-ins accepted within the replay window
+var CheckInNonces = replay.NewCache()
+
+// RaiseAlert adds an alert to the stream, and records it in the log and audit trail
+func RaiseAlert(alert models.Alert) models.Alert {
+	alert = Alerts.add(alert)
+
+	log.Printf("ALERT: %s from agent %s (%s): %s", alert.Kind, alert.AgentID, alert.SourceIP, alert.Detail)
+
+	events.Publish(models.Event{
+		Type:    models.EventAlert,
+		AgentID: alert.AgentID,
+		JobID:   alert.JobID,
+		Status:  alert.Kind,
+		Detail:  alert.Detail,
+	})
+
+	audit.Record(audit.Entry{
+		Action:   "alert",
+		SourceIP: alert.SourceIP,
+		AgentID:  alert.AgentID,
+		JobID:    alert.JobID,
+		Outcome:  alert.Kind + ": " + alert.Detail,
+	})
+
+	return alert
+}
+
+// add numbers and timestamps an alert, dropping the oldest one when the ring is full
+func (al *AlertLog) add(alert models.Alert) models.Alert {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	al.nextID++
+	alert.ID = al.nextID
+	alert.Time = time.Now().UTC()
+
+	if len(al.alerts) == maxAlerts {
+		al.alerts = append(al.alerts[:0], al.alerts[1:]...)
+	}
+	al.alerts = append(al.alerts, alert)
+
+	return alert
+}
+
+// List returns the alerts with an ID greater than afterID, oldest first
+func (al *AlertLog) List(afterID uint64) []models.Alert {
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+
+	alerts := make([]models.Alert, 0)
+	for _, alert := range al.alerts {
+		if alert.ID > afterID {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	return alerts
+}
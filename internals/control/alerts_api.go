@@ -0,0 +1,26 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// listAlertsHandler returns recent alerts, only those after ?after=<id> when polling for new ones
+func listAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	var afterID uint64
+
+	if after := r.URL.Query().Get("after"); after != "" {
+		n, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Invalid after: %s", after))
+			return
+		}
+		afterID = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Alerts.List(afterID))
+}
@@ -0,0 +1,275 @@
+package control
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"workshop3_dev/internals/audit"
+)
+
+// Role decides which control API endpoints and commands an operator may use
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // Read-only access to agents and jobs
+	RoleOperator Role = "operator" // Can also queue commands
+	RoleAdmin    Role = "admin"    // Can do everything, including server administration
+)
+
+// adminTokenFile is where in the data directory the token of a bootstrapped admin is written
+const adminTokenFile = "admin.token"
+
+// roleRank orders the roles so that each one includes the permissions of those below it
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Operator is a user of the control API
+type Operator struct {
+	Name        string   `yaml:"name"`
+	Role        Role     `yaml:"role"`
+	TokenSHA256 string   `yaml:"token_sha256"`       // Hex SHA-256 of the operator's API token
+	Commands    []string `yaml:"commands,omitempty"` // Optional allowlist, empty means every command the role permits
+}
+
+// HasRole reports whether the operator's role includes the permissions of role
+func (op Operator) HasRole(role Role) bool {
+	return roleRank[op.Role] >= roleRank[role]
+}
+
+// CanRun reports whether the operator may queue the given command
+func (op Operator) CanRun(command string) bool {
+	cmdConfig, exists := validCommands[command]
+	if !exists || !op.HasRole(cmdConfig.MinRole) {
+		return false
+	}
+
+	if len(op.Commands) == 0 {
+		return true
+	}
+
+	for _, allowed := range op.Commands {
+		if allowed == command {
+			return true
+		}
+	}
+	return false
+}
+
+// OperatorRegistry holds the operators allowed to use the control API
+type OperatorRegistry struct {
+	operators []Operator
+	mu        sync.RWMutex
+}
+
+// Operators is the global operator registry
+var Operators OperatorRegistry
+
+// LoadOperators reads the operators file, if it does not exist a single admin with a random token is created instead
+// and the token written to admin.token in dataDir
+func LoadOperators(path string, dataDir string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return bootstrapAdmin(path, dataDir)
+	}
+	if err != nil {
+		return fmt.Errorf("reading operators file: %w", err)
+	}
+
+	var file struct {
+		Operators []Operator `yaml:"operators"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parsing operators file: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, op := range file.Operators {
+		if op.Name == "" {
+			return fmt.Errorf("operator without a name in %s", path)
+		}
+		if seen[op.Name] {
+			return fmt.Errorf("operator %s is defined twice", op.Name)
+		}
+		seen[op.Name] = true
+
+		if _, known := roleRank[op.Role]; !known {
+			return fmt.Errorf("operator %s has unknown role %q", op.Name, op.Role)
+		}
+		if op.TokenSHA256 != "" {
+			if digest, err := hex.DecodeString(op.TokenSHA256); err != nil || len(digest) != sha256.Size {
+				return fmt.Errorf("operator %s: token_sha256 must be 64 hex characters", op.Name)
+			}
+		}
+		for _, command := range op.Commands {
+			if _, exists := validCommands[command]; !exists {
+				return fmt.Errorf("operator %s is allowed unknown command %q", op.Name, command)
+			}
+		}
+	}
+
+	if len(file.Operators) == 0 {
+		return fmt.Errorf("no operators defined in %s", path)
+	}
+
+	Operators.set(file.Operators)
+	log.Printf("Loaded %d operators from %s", len(file.Operators), path)
+
+	return nil
+}
+
+// bootstrapAdmin creates a temporary admin so a fresh install is never left wide open. The token only goes to a
+// file only the server's user can read, never to the log, which is kept and shipped elsewhere.
+func bootstrapAdmin(path string, dataDir string) error {
+	tokenBytes := make([]byte, 24)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return fmt.Errorf("generating admin token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	tokenPath := filepath.Join(dataDir, adminTokenFile)
+	if err := writeSecret(tokenPath, token+"\n"); err != nil {
+		return fmt.Errorf("writing admin token: %w", err)
+	}
+
+	Operators.set([]Operator{{
+		Name:        "admin",
+		Role:        RoleAdmin,
+		TokenSHA256: hashToken(token),
+	}})
+
+	log.Printf("WARNING: No operators file at %s, created temporary admin for this run only", path)
+	log.Printf("WARNING: Its bearer token is in %s, readable only by this user", tokenPath)
+
+	return nil
+}
+
+// writeSecret replaces the file at path with one only its owner can read. The old file is removed first, so the
+// content is never written into a file someone else already has open or made readable.
+func writeSecret(path string, content string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (or *OperatorRegistry) set(operators []Operator) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	or.operators = operators
+}
+
+// byToken finds the operator whose token hashes to the stored digest
+func (or *OperatorRegistry) byToken(token string) (Operator, bool) {
+	or.mu.RLock()
+	defer or.mu.RUnlock()
+
+	digest := hashToken(token)
+	for _, op := range or.operators {
+		if op.TokenSHA256 != "" && subtle.ConstantTimeCompare([]byte(digest), []byte(strings.ToLower(op.TokenSHA256))) == 1 {
+			return op, true
+		}
+	}
+	return Operator{}, false
+}
+
+// byName finds an operator by the name in their client certificate
+func (or *OperatorRegistry) byName(name string) (Operator, bool) {
+	or.mu.RLock()
+	defer or.mu.RUnlock()
+
+	for _, op := range or.operators {
+		if op.Name == name {
+			return op, true
+		}
+	}
+	return Operator{}, false
+}
+
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+type operatorContextKey struct{}
+
+// OperatorFromContext returns the authenticated operator attached to a request context
+func OperatorFromContext(ctx context.Context) (Operator, bool) {
+	op, ok := ctx.Value(operatorContextKey{}).(Operator)
+	return op, ok
+}
+
+// authenticate identifies the operator by verified client certificate or bearer token
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var op Operator
+		var found bool
+
+		// A certificate that passed verification against the client CA takes precedence
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			op, found = Operators.byName(r.TLS.VerifiedChains[0][0].Subject.CommonName)
+		} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			op, found = Operators.byToken(token)
+		}
+
+		if !found {
+			log.Printf("Rejected unauthenticated control API request from %s to %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+			audit.Record(audit.Entry{
+				Action:   "auth_failed",
+				SourceIP: audit.SourceIP(r.RemoteAddr),
+				Outcome:  fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+			})
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode("ERROR: authentication required")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), operatorContextKey{}, op)))
+	})
+}
+
+// requireRole only lets operators with at least the given role through
+func requireRole(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			op, _ := OperatorFromContext(r.Context())
+			if !op.HasRole(role) {
+				log.Printf("Operator %s (%s) denied %s %s", op.Name, op.Role, r.Method, r.URL.Path)
+				audit.Record(audit.Entry{
+					Action:   "access_denied",
+					Operator: op.Name,
+					SourceIP: audit.SourceIP(r.RemoteAddr),
+					Outcome:  fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+				})
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: %s role required", role))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
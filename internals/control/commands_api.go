@@ -0,0 +1,33 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"workshop3_dev/internals/models"
+)
+
+// listCommandsHandler returns the registered commands the calling operator is allowed to run
+func listCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	op, _ := OperatorFromContext(r.Context())
+
+	commands := make([]models.CommandInfo, 0, len(validCommands))
+	for name, cmd := range validCommands {
+		if !op.CanRun(name) {
+			continue
+		}
+		commands = append(commands, models.CommandInfo{
+			Name:        name,
+			Description: cmd.Description,
+			Arguments:   cmd.Arguments,
+			MinRole:     string(cmd.MinRole),
+		})
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(commands)
+}
@@ -1,27 +1,129 @@
 package control
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"log"
 	"net/http"
+	"os"
+	"time"
+	"workshop3_dev/internals/audit"
+	"workshop3_dev/internals/events"
 	"workshop3_dev/internals/models"
 )
 
-func StartControlAPI() {
+// ControlAPIConfig holds the settings for the operator-facing control API
+type ControlAPIConfig struct {
+	Address       string
+	OperatorsFile string
+	DataDir       string // Where the token of a bootstrapped admin is written when there is no operators file
+	TLSCert       string // Optional, serve the control API over HTTPS
+	TLSKey        string
+	ClientCA      string // Optional, also accept operator client certificates issued by this CA
+}
+
+func StartControlAPI(cfg ControlAPIConfig) error {
+	if Listeners == nil || PKI == nil || Signer == nil {
+		return fmt.Errorf("listener manager, PKI and signer must be configured before starting the control API")
+	}
+
+	// Nobody gets in without being a known operator
+	if err := LoadOperators(cfg.OperatorsFile, cfg.DataDir); err != nil {
+		return err
+	}
+
 	// Create Chi router
 	r := chi.NewRouter()
+	r.Use(authenticate)
+
+	// Define the GET endpoints for the agent inventory and following up on jobs
+	r.Group(func(r chi.Router) {
+		r.Use(requireRole(RoleViewer))
+		r.Get("/agents", listAgentsHandler)
+		r.Get("/agents/{id}", getAgentHandler)
+		r.Get("/jobs", listJobsHandler)
+		r.Get("/jobs/{id}", getJobHandler)
+		r.Get("/listeners", listListenersHandler)
+		r.Get("/pki", pkiInfoHandler)
+		r.Get("/signing-key", signingKeyHandler)
+		r.Get("/alerts", listAlertsHandler)
+		r.Get("/commands", listCommandsHandler)
+		r.Get("/events", eventsHandler)
+		r.Get("/queue", listQueuesHandler)
+		r.Get("/agents/{id}/queue", getAgentQueueHandler)
+		r.Get("/payloads", listPayloadsHandler)
+		r.Get("/payloads/{ref}", getPayloadHandler)
+	})
+
+	// Define the POST endpoints for tasking, the agent is either in the body or in the path, and for managing queued jobs
+	r.Group(func(r chi.Router) {
+		r.Use(requireRole(RoleOperator))
+		r.Post("/command", commandHandler)
+		r.Post("/agents/{id}/command", agentCommandHandler)
+		r.Put("/agents/{id}/tags", setAgentTagsHandler)
+		r.Post("/tags/{tag}/command", tagCommandHandler)
+		r.Post("/jobs/{id}/cancel", cancelJobHandler)
+		r.Post("/jobs/{id}/priority", setPriorityHandler)
+		r.Post("/jobs/{id}/move", moveJobHandler)
+		r.Post("/payloads", uploadPayloadHandler)
+	})
+
+	// Define the admin endpoints for managing listeners and certificates
+	r.Group(func(r chi.Router) {
+		r.Use(requireRole(RoleAdmin))
+		r.Post("/listeners", createListenerHandler)
+		r.Post("/listeners/{id}/start", startListenerHandler)
+		r.Post("/listeners/{id}/stop", stopListenerHandler)
+		r.Delete("/listeners/{id}", deleteListenerHandler)
+		r.Post("/pki/rotate", rotateCertificateHandler)
+		r.Post("/pki/agent-cert", issueClientCertHandler)
+	})
+
+	server := &http.Server{
+		Addr:    cfg.Address,
+		Handler: r,
+	}
+
+	if cfg.ClientCA != "" {
+		if cfg.TLSCert == "" {
+			return fmt.Errorf("client certificate authentication requires the control API to use TLS")
+		}
+
+		caPEM, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("reading client CA: %w", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in %s", cfg.ClientCA)
+		}
 
-	// Define the POST endpoint
-	r.Post("/command", commandHandler)
+		// Certificates are optional so that operators can still fall back to tokens
+		server.TLSConfig = &tls.Config{
+			ClientCAs:  clientCAs,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
 
-	log.Println("Starting Control API on :8080")
 	go func() {
-		if err := http.ListenAndServe(":8080", r); err != nil {
+		var err error
+		if cfg.TLSCert != "" {
+			log.Printf("Starting Control API on https://%s", cfg.Address)
+			err = server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			log.Printf("Starting Control API on http://%s", cfg.Address)
+			err = server.ListenAndServe()
+		}
+		if err != nil {
 			log.Printf("Control API error: %v", err)
 		}
 	}()
+
+	return nil
 }
 
 func commandHandler(w http.ResponseWriter, r *http.Request) {
@@ -37,49 +139,223 @@ func commandHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	queueCommand(w, r, cmdClient)
+}
+
+// agentCommandHandler queues a command for the agent named in the URL path
+func agentCommandHandler(w http.ResponseWriter, r *http.Request) {
+
+	var cmdClient models.CommandClient
+
+	if err := json.NewDecoder(r.Body).Decode(&cmdClient); err != nil {
+		log.Printf("ERROR: Failed to decode JSON: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode("error decoding JSON")
+		return
+	}
+
+	// The path always wins over anything set in the body
+	cmdClient.AgentID = chi.URLParam(r, "id")
+
+	queueCommand(w, r, cmdClient)
+}
+
+// tagCommandHandler queues a command for every agent carrying the tag named in the URL path
+func tagCommandHandler(w http.ResponseWriter, r *http.Request) {
+
+	var cmdClient models.CommandClient
+
+	if err := json.NewDecoder(r.Body).Decode(&cmdClient); err != nil {
+		log.Printf("ERROR: Failed to decode JSON: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode("error decoding JSON")
+		return
+	}
+
+	cmdClient.AgentID = ""
+	cmdClient.Tag = chi.URLParam(r, "tag")
+
+	queueCommand(w, r, cmdClient)
+}
+
+// queueCommand validates, processes and queues a command for a single agent, or for every agent with a tag
+func queueCommand(w http.ResponseWriter, r *http.Request, cmdClient models.CommandClient) {
+	op, _ := OperatorFromContext(r.Context())
+
+	target := "agent " + cmdClient.AgentID
+	if cmdClient.Tag != "" {
+		target = "tag " + cmdClient.Tag
+	}
+
 	// Visually confirm we get the command we expected
-	var commandReceived = fmt.Sprintf("Received command: %s", cmdClient.Command)
+	var commandReceived = fmt.Sprintf("Received command: %s for %s from %s", cmdClient.Command, target, op.Name)
 	log.Printf(commandReceived)
 
+	// Every command has to be addressed to an agent we know about, or to a tag at least one agent carries
+	if (cmdClient.AgentID == "") == (cmdClient.Tag == "") {
+		rejectCommand(w, r, cmdClient, http.StatusBadRequest, "ERROR: exactly one of agent_id or tag is required")
+		return
+	}
+
+	var agentIDs []string
+	if cmdClient.Tag != "" {
+		for _, agent := range Agents.WithTag(cmdClient.Tag) {
+			agentIDs = append(agentIDs, agent.ID)
+		}
+		if len(agentIDs) == 0 {
+			rejectCommand(w, r, cmdClient, http.StatusNotFound, fmt.Sprintf("ERROR: No agents are tagged %s", cmdClient.Tag))
+			return
+		}
+	} else if _, known := Agents.Get(cmdClient.AgentID); !known {
+		rejectCommand(w, r, cmdClient, http.StatusNotFound, fmt.Sprintf("ERROR: Unknown agent: %s", cmdClient.AgentID))
+		return
+	}
+
 	// Check if command exists
 	cmdConfig, exists := validCommands[cmdClient.Command]
 	if !exists {
-		var commandInvalid = fmt.Sprintf("ERROR: Unknown command: %s", cmdClient.Command)
-		log.Printf(commandInvalid)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(commandInvalid)
+		rejectCommand(w, r, cmdClient, http.StatusBadRequest, fmt.Sprintf("ERROR: Unknown command: %s", cmdClient.Command))
+		return
+	}
+
+	// Check the operator is allowed to run this command
+	if !op.CanRun(cmdClient.Command) {
+		rejectCommand(w, r, cmdClient, http.StatusForbidden, fmt.Sprintf("ERROR: Operator %s is not allowed to run '%s'", op.Name, cmdClient.Command))
+		return
+	}
+
+	if err := validateSchedule(cmdClient, time.Now()); err != nil {
+		rejectCommand(w, r, cmdClient, http.StatusBadRequest, fmt.Sprintf("ERROR: Invalid schedule: %v", err))
 		return
 	}
 
 	// Validate arguments
-	if err := cmdConfig.Validator(cmdClient.Arguments); err != nil {
-		var commandInvalid = fmt.Sprintf("ERROR: Validation failed for '%s': %v", cmdClient.Command, err)
-		log.Printf(commandInvalid)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(commandInvalid)
+	report, loaded, err := cmdConfig.Validator(cmdClient.Arguments)
+	if err != nil {
+		reportPathRejection(r, cmdClient, err)
+		rejectCommand(w, r, cmdClient, http.StatusBadRequest, fmt.Sprintf("ERROR: Validation failed for '%s': %v", cmdClient.Command, err))
 		return
 	}
 
+	// Keep a digest of what the operator actually asked for before the arguments are processed
+	argsDigest := audit.Digest(cmdClient.Arguments)
+
 	// Process arguments (e.g., load file and convert to base64)
-	processedArgs, err := cmdConfig.Processor(cmdClient.Arguments)
+	processedArgs, err := cmdConfig.Processor(cmdClient.Arguments, loaded)
 	if err != nil {
-		var commandInvalid = fmt.Sprintf("ERROR: Processing failed for '%s': %v", cmdClient.Command, err)
-		log.Printf(commandInvalid)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(commandInvalid)
+		rejectCommand(w, r, cmdClient, http.StatusBadRequest, fmt.Sprintf("ERROR: Processing failed for '%s': %v", cmdClient.Command, err))
 		return
-
 	}
 
 	// Update command with processed arguments
 	cmdClient.Arguments = processedArgs
 	log.Printf("Processed command arguments: %s", cmdClient.Command)
 
-	// Queue the validated and processed command
-	AgentCommands.addCommand(cmdClient)
+	// A single agent gets its job directly, a tag gets a parent job and a job per agent under it
+	if cmdClient.Tag == "" {
+		job := Jobs.Create(cmdClient)
+		enqueue(r, job, argsDigest)
+
+		// Confirm on the client side command was received, and tell it which job to follow
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(models.CommandResponse{
+			JobID:   job.ID,
+			Report:  report,
+			Message: commandReceived,
+		})
+		return
+	}
+
+	parent, children := Jobs.Broadcast(cmdClient, agentIDs)
+	log.Printf("BROADCAST: %s as %s to %d agents tagged %s", parent.Command, parent.ID, len(children), parent.Tag)
+
+	audit.Record(audit.Entry{
+		Action:     "command_broadcast",
+		Operator:   op.Name,
+		SourceIP:   audit.SourceIP(r.RemoteAddr),
+		JobID:      parent.ID,
+		Command:    parent.Command,
+		ArgsSHA256: argsDigest,
+		Outcome:    fmt.Sprintf("%d agents tagged %s", len(children), parent.Tag),
+	})
+
+	childIDs := make([]string, 0, len(children))
+	for _, job := range children {
+		enqueue(r, job, argsDigest)
+		childIDs = append(childIDs, job.ID)
+	}
 
-	// Confirm on the client side command was received
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(commandReceived)
+	json.NewEncoder(w).Encode(models.CommandResponse{
+		JobID:    parent.ID,
+		Children: childIDs,
+		Report:   report,
+		Message:  commandReceived,
+	})
+}
+
+// enqueue queues a newly created job, then audits and publishes it. A recurring job stays out of the queue and
+// queues its runs as they fall due.
+func enqueue(r *http.Request, job models.Job, argsDigest string) {
+	op, _ := OperatorFromContext(r.Context())
+
+	if job.Status == models.JobQueued {
+		AgentCommands.addCommand(job)
+	}
+
+	audit.Record(audit.Entry{
+		Action:     "command_queued",
+		Operator:   op.Name,
+		SourceIP:   audit.SourceIP(r.RemoteAddr),
+		AgentID:    job.AgentID,
+		JobID:      job.ID,
+		Command:    job.Command,
+		ArgsSHA256: argsDigest,
+		Outcome:    string(job.Status),
+	})
+
+	events.Publish(models.Event{
+		Type:     models.EventJobQueued,
+		AgentID:  job.AgentID,
+		JobID:    job.ID,
+		ParentID: job.ParentID,
+		Command:  job.Command,
+		Status:   string(job.Status),
+		Detail:   "queued by " + op.Name,
+	})
+}
+
+// reportPathRejection raises an alert when a command was refused for trying to read outside the payload roots
+func reportPathRejection(r *http.Request, cmdClient models.CommandClient, err error) {
+	if !errors.Is(err, ErrPathNotAllowed) {
+		return
+	}
+
+	op, _ := OperatorFromContext(r.Context())
+	RaiseAlert(models.Alert{
+		Kind:     AlertPathRejected,
+		AgentID:  cmdClient.AgentID,
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		Detail:   fmt.Sprintf("%s by operator %s: %v", cmdClient.Command, op.Name, err),
+	})
+}
+
+// rejectCommand logs and audits a command that will not be queued, then reports why to the client
+func rejectCommand(w http.ResponseWriter, r *http.Request, cmdClient models.CommandClient, status int, message string) {
+	op, _ := OperatorFromContext(r.Context())
+
+	log.Printf(message)
+
+	audit.Record(audit.Entry{
+		Action:     "command_rejected",
+		Operator:   op.Name,
+		SourceIP:   audit.SourceIP(r.RemoteAddr),
+		AgentID:    cmdClient.AgentID,
+		Command:    cmdClient.Command,
+		ArgsSHA256: audit.Digest(cmdClient.Arguments),
+		Outcome:    message,
+	})
 
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(message)
 }
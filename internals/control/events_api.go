@@ -0,0 +1,106 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"workshop3_dev/internals/events"
+	"workshop3_dev/internals/models"
+)
+
+// keepAliveInterval stops idle proxies from closing a quiet event stream
+const keepAliveInterval = 15 * time.Second
+
+// eventsHandler streams server events as Server-Sent Events.
+// Reconnecting clients send Last-Event-ID (or ?after=) to catch up on what they missed,
+// and ?type= takes a comma separated list of event types to receive.
+// The stream ends when the client falls too far behind, it should reconnect with Last-Event-ID.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Without a starting point only new events are sent, starting from whatever was published last
+	afterID := events.LastID()
+
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	if after != "" {
+		n, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Invalid event ID: %s", after))
+			return
+		}
+		afterID = n
+	}
+
+	var types []models.EventType
+	if typeList := r.URL.Query().Get("type"); typeList != "" {
+		for _, t := range strings.Split(typeList, ",") {
+			types = append(types, models.EventType(strings.TrimSpace(t)))
+		}
+	}
+
+	// Filtered by the bus, so events the client did not ask for never take up its buffer
+	backlog, stream, unsubscribe := events.Subscribe(afterID, types...)
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event models.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	// An ID on its own tells the client where the stream starts, so it can resume from there even if the
+	// stream ends before any event reaches it
+	if _, err := fmt.Fprintf(w, "id: %d\n\n", afterID); err != nil {
+		return
+	}
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-stream:
+			if !ok {
+				// Fell behind and was cut off by the bus, the client picks up from history when it reconnects
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"workshop3_dev/internals/events"
+	"workshop3_dev/internals/models"
+)
+
+var (
+	// ErrJobNotFound is returned for a job ID the store has never seen
+	ErrJobNotFound = errors.New("unknown job")
+	// ErrJobNotQueued is returned when changing a job that has already left the queue
+	ErrJobNotQueued = errors.New("job is no longer queued")
+)
+
+// JobStore keeps the lifecycle of every job the server has queued
+type JobStore struct {
+	jobs       map[string]*models.Job
+	recurring  map[string]map[string]*models.Job // Recurring jobs by agent, so a check-in only goes through its own
+	unflushed  map[string]bool                   // Recurring jobs whose check-in count has not been written to storage yet
+	broadcasts map[string][]*models.Job          // Per-agent jobs of every broadcast still running, by parent
+	nextID     uint64
+	mu         sync.RWMutex
+}
+
+// JobFilter narrows down the jobs returned by List, zero values match everything
+type JobFilter struct {
+	AgentID  string
+	Command  string
+	Status   models.JobStatus
+	ParentID string
+	Since    time.Time // Only jobs queued at or after this time
+	Until    time.Time // Only jobs queued before this time
+	Offset   int
+	Limit    int
+}
+
+// matches reports whether a job satisfies every field set in the filter
+func (f JobFilter) matches(job *models.Job) bool {
+	if f.AgentID != "" && job.AgentID != f.AgentID {
+		return false
+	}
+	if f.Command != "" && job.Command != f.Command {
+		return false
+	}
+	if f.Status != "" && job.Status != f.Status {
+		return false
+	}
+	if f.ParentID != "" && job.ParentID != f.ParentID {
+		return false
+	}
+	if !f.Since.IsZero() && job.QueuedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !job.QueuedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Jobs is the global job store
+var Jobs = JobStore{
+	jobs:       make(map[string]*models.Job),
+	recurring:  make(map[string]map[string]*models.Job),
+	unflushed:  make(map[string]bool),
+	broadcasts: make(map[string][]*models.Job),
+}
+
+// Create records a new job for a validated command and assigns it a unique ID.
+// A command with a recurrence becomes a recurring job that queues a run of itself whenever it is due.
+func (js *JobStore) Create(command models.CommandClient) models.Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	return js.create(command, command.AgentID, "")
+}
+
+// Broadcast records a parent job for a command sent to a tag, and a job of its own for each of the tagged agents
+func (js *JobStore) Broadcast(command models.CommandClient, agentIDs []string) (models.Job, []models.Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	js.nextID++
+	parent := &models.Job{
+		ID:        fmt.Sprintf("job_%06d", js.nextID),
+		Command:   command.Command,
+		Status:    models.JobBroadcast,
+		Priority:  command.Priority,
+		QueuedAt:  time.Now(),
+		NotBefore: command.NotBefore,
+		NotAfter:  command.NotAfter,
+		Tag:       command.Tag,
+	}
+	js.jobs[parent.ID] = parent
+	persistJob(*parent)
+
+	children := make([]models.Job, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		child := js.create(command, agentID, parent.ID)
+		children = append(children, child)
+		js.broadcasts[parent.ID] = append(js.broadcasts[parent.ID], js.jobs[child.ID])
+	}
+
+	return *parent, children
+}
+
+// create records a job for one agent, caller holds mu
+func (js *JobStore) create(command models.CommandClient, agentID string, parentID string) models.Job {
+	// IDs come from a counter rather than a random number so they can never collide
+	js.nextID++
+
+	job := &models.Job{
+		ID:        fmt.Sprintf("job_%06d", js.nextID),
+		AgentID:   agentID,
+		ParentID:  parentID,
+		Command:   command.Command,
+		Arguments: command.Arguments,
+		Status:    models.JobQueued,
+		Priority:  command.Priority,
+		QueuedAt:  time.Now(),
+		NotBefore: command.NotBefore,
+		NotAfter:  command.NotAfter,
+	}
+	if command.Recurrence != nil {
+		job.Status = models.JobRecurring
+		job.Recurrence = command.Recurrence
+		if job.Recurrence.Cron != "" {
+			job.NextRunAt = nextCronRun(job, job.QueuedAt)
+		}
+	}
+	js.jobs[job.ID] = job
+	js.indexRecurring(job, job.AgentID)
+
+	persistJob(*job)
+
+	return *job
+}
+
+// Get returns a copy of a single job
+func (js *JobStore) Get(jobID string) (models.Job, bool) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	job, exists := js.jobs[jobID]
+	if !exists {
+		return models.Job{}, false
+	}
+
+	return *job, true
+}
+
+// Children returns the runs of a recurring job or the per-agent jobs of a broadcast, oldest first
+func (js *JobStore) Children(parentID string) []models.Job {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	children := make([]models.Job, 0)
+	for _, job := range js.jobs {
+		if job.ParentID == parentID {
+			children = append(children, *job)
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return queuedBefore(children[i], children[j])
+	})
+
+	return children
+}
+
+// List returns one page of the jobs matching the filter, oldest first, along with the total number of matches
+func (js *JobStore) List(filter JobFilter) ([]models.Job, int) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	matched := make([]models.Job, 0)
+	for _, job := range js.jobs {
+		if filter.matches(job) {
+			matched = append(matched, *job)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return queuedBefore(matched[i], matched[j])
+	})
+
+	total := len(matched)
+	if filter.Offset >= total {
+		return []models.Job{}, total
+	}
+
+	end := total
+	if filter.Limit > 0 && filter.Offset+filter.Limit < total {
+		end = filter.Offset + filter.Limit
+	}
+
+	return matched[filter.Offset:end], total
+}
+
+// queuedBefore orders jobs by when they were queued, then by ID for jobs queued at the same moment
+func queuedBefore(a, b models.Job) bool {
+	if !a.QueuedAt.Equal(b.QueuedAt) {
+		return a.QueuedAt.Before(b.QueuedAt)
+	}
+	return jobNumber(a.ID) < jobNumber(b.ID)
+}
+
+// jobNumber is the counter value a job ID was made from, so job_1000000 sorts after job_999999
+func jobNumber(jobID string) uint64 {
+	var n uint64
+	fmt.Sscanf(jobID, "job_%d", &n)
+	return n
+}
+
+// MarkDispatched records that a queued job has been handed to its agent
+func (js *JobStore) MarkDispatched(jobID string) (models.Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	job, exists := js.jobs[jobID]
+	if !exists || job.Status != models.JobQueued {
+		return models.Job{}, false
+	}
+
+	now := time.Now()
+	job.Status = models.JobDispatched
+	job.DispatchedAt = &now
+
+	persistJob(*job)
+
+	return *job, true
+}
+
+// updateQueued applies change to a job that is still queued or recurring, and persists it
+func (js *JobStore) updateQueued(jobID string, change func(job *models.Job)) (models.Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	job, exists := js.jobs[jobID]
+	if !exists {
+		return models.Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	if job.Status != models.JobQueued && job.Status != models.JobRecurring {
+		return models.Job{}, fmt.Errorf("%w: %s is %s", ErrJobNotQueued, jobID, job.Status)
+	}
+
+	previousAgent := job.AgentID
+	change(job)
+	js.indexRecurring(job, previousAgent)
+	persistJob(*job)
+	js.finishBroadcast(job)
+
+	return *job, nil
+}
+
+// Complete attaches an agent's result to its job and marks it as completed or failed
+func (js *JobStore) Complete(result models.AgentTaskResult) (models.Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	job, exists := js.jobs[result.JobID]
+	if !exists {
+		return models.Job{}, fmt.Errorf("unknown job: %s", result.JobID)
+	}
+
+	if job.AgentID != result.AgentID {
+		return models.Job{}, fmt.Errorf("job %s belongs to agent %s, not %s", job.ID, job.AgentID, result.AgentID)
+	}
+
+	// A result that arrives after the timeout is still worth keeping
+	if job.Status != models.JobDispatched && job.Status != models.JobTimedOut {
+		return models.Job{}, fmt.Errorf("job %s is %s and cannot accept a result", job.ID, job.Status)
+	}
+
+	if job.Status == models.JobTimedOut {
+		log.Printf("Late result received for timed out job %s", job.ID)
+	}
+
+	now := time.Now()
+	job.CompletedAt = &now
+	job.Result = &result
+	if result.Success {
+		job.Status = models.JobCompleted
+	} else {
+		job.Status = models.JobFailed
+	}
+
+	persistJob(*job)
+	js.finishBroadcast(job)
+
+	return *job, nil
+}
+
+// TimeOutExpired marks every job that has been dispatched for longer than timeout as timed out
+func (js *JobStore) TimeOutExpired(timeout time.Duration) []models.Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	now := time.Now()
+	var expired []models.Job
+
+	for _, job := range js.jobs {
+		if job.Status != models.JobDispatched || now.Sub(*job.DispatchedAt) < timeout {
+			continue
+		}
+		job.Status = models.JobTimedOut
+		job.CompletedAt = &now
+		persistJob(*job)
+		js.finishBroadcast(job)
+		expired = append(expired, *job)
+	}
+
+	return expired
+}
+
+// DecodeCommandResult unwraps the message string an agent placed in CommandResult
+func DecodeCommandResult(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var messageStr string
+	if err := json.Unmarshal(raw, &messageStr); err != nil {
+		return string(raw) // Fallback to raw bytes as string
+	}
+
+	return messageStr
+}
+
+// StartJobTimeoutWatcher periodically times out jobs whose agent never reported back
+func StartJobTimeoutWatcher(timeout time.Duration) {
+	go func() {
+		ticker := time.NewTicker(timeout / 10)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, job := range Jobs.TimeOutExpired(timeout) {
+				log.Printf("Job %s on agent %s timed out after %v", job.ID, job.AgentID, timeout)
+				events.Publish(models.Event{
+					Type:     models.EventJobTimedOut,
+					AgentID:  job.AgentID,
+					JobID:    job.ID,
+					ParentID: job.ParentID,
+					Command:  job.Command,
+					Status:   string(job.Status),
+				})
+			}
+		}
+	}()
+}
@@ -0,0 +1,118 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"workshop3_dev/internals/models"
+)
+
+const (
+	defaultJobPageSize = 50
+	maxJobPageSize     = 500
+)
+
+// listJobsHandler returns the jobs matching the query parameters agent_id, command, status, parent_id, since, until, offset and limit
+func listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseJobFilter(r)
+	if err != nil {
+		var queryInvalid = fmt.Sprintf("ERROR: Invalid query: %v", err)
+		log.Printf(queryInvalid)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(queryInvalid)
+		return
+	}
+
+	jobs, total := Jobs.List(filter)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.JobList{
+		Total:  total,
+		Offset: filter.Offset,
+		Limit:  filter.Limit,
+		Jobs:   jobs,
+	})
+}
+
+// getJobHandler returns a single job with its result decoded, along with its runs or per-agent jobs
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "id")
+
+	job, exists := Jobs.Get(jobID)
+	if !exists {
+		var jobUnknown = fmt.Sprintf("ERROR: Unknown job: %s", jobID)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(jobUnknown)
+		return
+	}
+
+	details := jobDetails(job)
+	for _, child := range Jobs.Children(job.ID) {
+		if details.Summary == nil {
+			details.Summary = make(map[models.JobStatus]int)
+		}
+		details.Children = append(details.Children, jobDetails(child))
+		details.Summary[child.Status]++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
+
+// jobDetails adds the decoded output of its result to a job
+func jobDetails(job models.Job) models.JobDetails {
+	details := models.JobDetails{Job: job}
+	if job.Result != nil {
+		details.Output = DecodeCommandResult(job.Result.CommandResult)
+	}
+	return details
+}
+
+// parseJobFilter builds a JobFilter from the request's query string
+func parseJobFilter(r *http.Request) (JobFilter, error) {
+	query := r.URL.Query()
+
+	filter := JobFilter{
+		AgentID:  query.Get("agent_id"),
+		Command:  query.Get("command"),
+		Status:   models.JobStatus(query.Get("status")),
+		ParentID: query.Get("parent_id"),
+		Limit:    defaultJobPageSize,
+	}
+
+	switch filter.Status {
+	case "", models.JobQueued, models.JobDispatched, models.JobCompleted, models.JobFailed, models.JobTimedOut, models.JobCancelled,
+		models.JobExpired, models.JobRecurring, models.JobBroadcast:
+	default:
+		return filter, fmt.Errorf("unknown status: %s", filter.Status)
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("since must be RFC3339: %w", err)
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("until must be RFC3339: %w", err)
+		}
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		if filter.Offset, err = strconv.Atoi(offset); err != nil || filter.Offset < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 || filter.Limit > maxJobPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxJobPageSize)
+		}
+	}
+
+	return filter, nil
+}
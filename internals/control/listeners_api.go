@@ -0,0 +1,143 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"workshop3_dev/internals/audit"
+	"workshop3_dev/internals/models"
+)
+
+// ListenerManager creates and controls the listeners agents connect to.
+// It is implemented by the server package and kept as an interface here to avoid an import cycle.
+type ListenerManager interface {
+	Create(cfg models.ListenerConfig) (models.Listener, error)
+	Start(id string) (models.Listener, error)
+	Stop(id string) (models.Listener, error)
+	Delete(id string) error
+	Get(id string) (models.Listener, bool)
+	List() []models.Listener
+}
+
+// Listeners is the listener manager used by the control API, set by main before the API starts
+var Listeners ListenerManager
+
+// listListenersHandler returns every listener
+func listListenersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Listeners.List())
+}
+
+// createListenerHandler creates a listener and starts it unless ?start=false is given, removing it again if it fails to start
+func createListenerHandler(w http.ResponseWriter, r *http.Request) {
+	var cfg models.ListenerConfig
+
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		log.Printf("ERROR: Failed to decode JSON: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode("error decoding JSON")
+		return
+	}
+
+	listener, err := Listeners.Create(cfg)
+	if err != nil {
+		listenerError(w, r, "listener_create", cfg.Name, http.StatusBadRequest, err)
+		return
+	}
+	auditListener(r, "listener_create", listener.ID, "created")
+
+	if r.URL.Query().Get("start") != "false" {
+		started, err := Listeners.Start(listener.ID)
+		if err != nil {
+			// The operator asked for a running listener, so one that could not start is not kept either
+			if deleteErr := Listeners.Delete(listener.ID); deleteErr != nil {
+				log.Printf("ERROR: Could not remove listener %s after it failed to start: %v", listener.ID, deleteErr)
+			} else {
+				auditListener(r, "listener_delete", listener.ID, "deleted after it failed to start")
+			}
+			listenerError(w, r, "listener_start", listener.ID, http.StatusConflict, err)
+			return
+		}
+		listener = started
+		auditListener(r, "listener_start", listener.ID, "started")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(listener)
+}
+
+// startListenerHandler starts a stopped listener
+func startListenerHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	listener, err := Listeners.Start(id)
+	if err != nil {
+		listenerError(w, r, "listener_start", id, listenerErrorStatus(id), err)
+		return
+	}
+	auditListener(r, "listener_start", id, "started")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listener)
+}
+
+// stopListenerHandler stops a running listener
+func stopListenerHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	listener, err := Listeners.Stop(id)
+	if err != nil {
+		listenerError(w, r, "listener_stop", id, listenerErrorStatus(id), err)
+		return
+	}
+	auditListener(r, "listener_stop", id, "stopped")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listener)
+}
+
+// deleteListenerHandler removes a stopped listener
+func deleteListenerHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if err := Listeners.Delete(id); err != nil {
+		listenerError(w, r, "listener_delete", id, listenerErrorStatus(id), err)
+		return
+	}
+	auditListener(r, "listener_delete", id, "deleted")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listenerErrorStatus tells an unknown listener apart from one in the wrong state
+func listenerErrorStatus(id string) int {
+	if _, exists := Listeners.Get(id); !exists {
+		return http.StatusNotFound
+	}
+	return http.StatusConflict
+}
+
+// listenerError logs, audits and reports a failed listener operation
+func listenerError(w http.ResponseWriter, r *http.Request, action string, id string, status int, err error) {
+	var message = fmt.Sprintf("ERROR: %v", err)
+	log.Printf(message)
+	auditListener(r, action, id, message)
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(message)
+}
+
+// auditListener records a listener operation against the operator who requested it
+func auditListener(r *http.Request, action string, id string, outcome string) {
+	op, _ := OperatorFromContext(r.Context())
+
+	audit.Record(audit.Entry{
+		Action:   action,
+		Operator: op.Name,
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		Outcome:  fmt.Sprintf("%s: %s", id, outcome),
+	})
+}
@@ -0,0 +1,121 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AlertPathRejected is raised when a task asks for a file outside the payload roots
+const AlertPathRejected = "path_rejected"
+
+// ErrPathNotAllowed is returned for a file_path that would read outside the payload roots
+var ErrPathNotAllowed = errors.New("file_path is not allowed")
+
+// payloadRoots are the directories file_path may read from, with symlinks resolved
+var payloadRoots []string
+
+// SetPayloadRoots confines file_path to the given directories, none disables file_path altogether
+func SetPayloadRoots(roots []string) error {
+	resolved := make([]string, 0, len(roots))
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return fmt.Errorf("payload root %s: %w", root, err)
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return fmt.Errorf("payload root %s: %w", root, err)
+		}
+		info, err := os.Stat(real)
+		if err != nil {
+			return fmt.Errorf("payload root %s: %w", root, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("payload root %s is not a directory", root)
+		}
+		resolved = append(resolved, real)
+	}
+
+	payloadRoots = resolved
+	return nil
+}
+
+// resolvePayloadPath returns the real path of a file inside one of the payload roots. A relative path is looked
+// up in each root in turn. Any ".." element, or a path or symlink leading outside the roots, is refused with
+// ErrPathNotAllowed.
+func resolvePayloadPath(path string) (string, error) {
+	if len(payloadRoots) == 0 {
+		return "", fmt.Errorf("%w: no payload roots are configured, upload the DLL to the payload library instead", ErrPathNotAllowed)
+	}
+
+	if strings.ContainsRune(path, 0) {
+		return "", fmt.Errorf("%w: %q contains a NUL byte", ErrPathNotAllowed, path)
+	}
+
+	// Refused outright, even when the cleaned path would still be inside a root
+	for _, element := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if element == ".." {
+			return "", fmt.Errorf("%w: %q contains a '..' element", ErrPathNotAllowed, path)
+		}
+	}
+
+	candidates := []string{filepath.Clean(path)}
+	if !filepath.IsAbs(path) {
+		candidates = candidates[:0]
+		for _, root := range payloadRoots {
+			candidates = append(candidates, filepath.Join(root, path))
+		}
+	}
+
+	for _, candidate := range candidates {
+		real, err := filepath.EvalSymlinks(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("resolving %s: %w", path, err)
+		}
+
+		// Checked after resolving, so a symlink inside a root cannot point anywhere else
+		if !insidePayloadRoot(real) {
+			if real == filepath.Clean(candidate) {
+				return "", fmt.Errorf("%w: %s is outside the payload roots", ErrPathNotAllowed, path)
+			}
+			return "", fmt.Errorf("%w: %s resolves to %s, outside the payload roots", ErrPathNotAllowed, path, real)
+		}
+
+		info, err := os.Stat(real)
+		if err != nil {
+			return "", fmt.Errorf("resolving %s: %w", path, err)
+		}
+		if !info.Mode().IsRegular() {
+			return "", fmt.Errorf("%s is not a regular file", path)
+		}
+
+		return real, nil
+	}
+
+	// Nothing there, but a path outside the roots is still an attempt worth reporting
+	if filepath.IsAbs(path) && !insidePayloadRoot(filepath.Clean(path)) {
+		return "", fmt.Errorf("%w: %s is outside the payload roots", ErrPathNotAllowed, path)
+	}
+
+	return "", fmt.Errorf("file does not exist in the payload roots: %s", path)
+}
+
+// insidePayloadRoot reports whether an absolute, cleaned path is one of the payload roots or below one
+func insidePayloadRoot(path string) bool {
+	for _, root := range payloadRoots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"workshop3_dev/internals/models"
+)
+
+// setupPayloadRoot creates a payload root holding good.dll and a symlink escaping it, next to a directory outside
+// every root holding secret.dll. Both directories are returned with symlinks resolved.
+func setupPayloadRoot(t *testing.T) (root string, outside string) {
+	t.Helper()
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err = filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(root, "good.dll"), filepath.Join(root, "sub", "nested.dll"), filepath.Join(outside, "secret.dll")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("MZ"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.dll"), filepath.Join(root, "escape.dll")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "good.dll"), filepath.Join(root, "alias.dll")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetPayloadRoots([]string{root}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { payloadRoots = nil })
+
+	return root, outside
+}
+
+// rejectedPaths are file_path values that must be refused with ErrPathNotAllowed
+func rejectedPaths(outside string) []struct{ name, path string } {
+	return []struct{ name, path string }{
+		{"parent traversal", "../" + filepath.Base(outside) + "/secret.dll"},
+		{"traversal back into the root", "sub/../good.dll"},
+		{"backslash traversal", `sub\..\..\secret.dll`},
+		{"absolute path outside the roots", filepath.Join(outside, "secret.dll")},
+		{"missing absolute path outside the roots", filepath.Join(outside, "missing.dll")},
+		{"symlink escaping the root", "escape.dll"},
+		{"NUL byte", "good.dll\x00.txt"},
+	}
+}
+
+func TestResolvePayloadPath(t *testing.T) {
+	root, outside := setupPayloadRoot(t)
+
+	allowed := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"relative file", "good.dll", filepath.Join(root, "good.dll")},
+		{"nested file", "sub/nested.dll", filepath.Join(root, "sub", "nested.dll")},
+		{"absolute path inside the root", filepath.Join(root, "good.dll"), filepath.Join(root, "good.dll")},
+		{"symlink inside the root", "alias.dll", filepath.Join(root, "good.dll")},
+	}
+	for _, tt := range allowed {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePayloadPath(tt.path)
+			if err != nil {
+				t.Fatalf("resolvePayloadPath(%q) failed: %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("resolvePayloadPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	for _, tt := range rejectedPaths(outside) {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := resolvePayloadPath(tt.path); !errors.Is(err, ErrPathNotAllowed) {
+				t.Errorf("resolvePayloadPath(%q) = %v, want ErrPathNotAllowed", tt.path, err)
+			}
+		})
+	}
+
+	t.Run("missing file inside the root", func(t *testing.T) {
+		_, err := resolvePayloadPath("missing.dll")
+		if err == nil || errors.Is(err, ErrPathNotAllowed) {
+			t.Errorf("resolvePayloadPath(missing.dll) = %v, want a not found error", err)
+		}
+	})
+
+	t.Run("no roots configured", func(t *testing.T) {
+		payloadRoots = nil
+		defer SetPayloadRoots([]string{root})
+
+		if _, err := resolvePayloadPath("good.dll"); !errors.Is(err, ErrPathNotAllowed) {
+			t.Errorf("resolvePayloadPath with no roots = %v, want ErrPathNotAllowed", err)
+		}
+	})
+}
+
+// TestPathRejectionAlerts queues shellcode tasks through the control API handler and checks every file_path
+// that escapes the roots is refused with an alert, while an allowed one raises none
+func TestPathRejectionAlerts(t *testing.T) {
+	_, outside := setupPayloadRoot(t)
+
+	agent := Agents.Register(models.RegisterRequest{Hostname: "test"}, "127.0.0.1:1", make([]byte, 32))
+	op := Operator{Name: "tester", Role: RoleOperator}
+
+	queue := func(path string) (int, []models.Alert) {
+		before := Alerts.List(0)
+		lastID := uint64(0)
+		if len(before) > 0 {
+			lastID = before[len(before)-1].ID
+		}
+
+		args, _ := json.Marshal(models.ShellcodeArgsClient{FilePath: path, ExportName: "Run"})
+		r := httptest.NewRequest(http.MethodPost, "/command", nil)
+		r = r.WithContext(context.WithValue(r.Context(), operatorContextKey{}, op))
+		w := httptest.NewRecorder()
+
+		queueCommand(w, r, models.CommandClient{AgentID: agent.ID, Command: "shellcode", Arguments: args})
+
+		var raised []models.Alert
+		for _, alert := range Alerts.List(lastID) {
+			if alert.Kind == AlertPathRejected {
+				raised = append(raised, alert)
+			}
+		}
+		return w.Code, raised
+	}
+
+	for _, tt := range rejectedPaths(outside) {
+		t.Run(tt.name, func(t *testing.T) {
+			status, raised := queue(tt.path)
+			if status != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", status, http.StatusBadRequest)
+			}
+			if len(raised) != 1 {
+				t.Fatalf("raised %d %s alerts, want 1", len(raised), AlertPathRejected)
+			}
+			if raised[0].AgentID != agent.ID {
+				t.Errorf("alert is for agent %q, want %q", raised[0].AgentID, agent.ID)
+			}
+		})
+	}
+
+	t.Run("allowed file", func(t *testing.T) {
+		// good.dll is not a real DLL, so validation still fails, but not because of where it is
+		if _, raised := queue("good.dll"); len(raised) != 0 {
+			t.Errorf("raised %d %s alerts for an allowed file: %+v", len(raised), AlertPathRejected, raised)
+		}
+	})
+}
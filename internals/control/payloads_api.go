@@ -0,0 +1,105 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"path/filepath"
+	"workshop3_dev/internals/audit"
+	"workshop3_dev/internals/payloads"
+)
+
+// maxUploadMemory is how much of a multipart upload is held in memory, the rest is spooled to a temporary file
+const maxUploadMemory = 8 << 20
+
+// Payloads is the library of uploaded payloads that tasks reference, set by main before the API starts
+var Payloads *payloads.Library
+
+// uploadPayloadHandler stores the "file" part of a multipart upload in the payload library.
+// The optional "name" part defaults to the uploaded file name, and "notes" is free text for other operators.
+func uploadPayloadHandler(w http.ResponseWriter, r *http.Request) {
+	op, _ := OperatorFromContext(r.Context())
+
+	// Leave room for the other parts and the multipart framing on top of the payload itself
+	r.Body = http.MaxBytesReader(w, r.Body, payloads.MaxSize+1<<20)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Invalid upload: %v", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode("ERROR: the upload needs a 'file' part")
+		return
+	}
+	defer file.Close()
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = filepath.Base(header.Filename)
+	}
+
+	payload, created, err := Payloads.Add(name, r.FormValue("notes"), op.Name, file)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, payloads.ErrTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		log.Printf("ERROR: Payload upload by %s failed: %v", op.Name, err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: %v", err))
+		return
+	}
+
+	outcome := fmt.Sprintf("stored as %s (%s)", payload.ID, payload.Name)
+	status := http.StatusCreated
+	if !created {
+		outcome = fmt.Sprintf("already stored as %s (%s)", payload.ID, payload.Name)
+		status = http.StatusOK
+	}
+
+	log.Printf("PAYLOAD: %d bytes, sha256 %s, uploaded by %s, %s", payload.Size, payload.SHA256, op.Name, outcome)
+	audit.Record(audit.Entry{
+		Action:     "payload_uploaded",
+		Operator:   op.Name,
+		SourceIP:   audit.SourceIP(r.RemoteAddr),
+		ArgsSHA256: payload.SHA256,
+		Outcome:    outcome,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+// listPayloadsHandler returns the payloads matching the query parameters name and sha256
+func listPayloadsHandler(w http.ResponseWriter, r *http.Request) {
+	filter := payloads.Filter{
+		Name:   r.URL.Query().Get("name"),
+		SHA256: r.URL.Query().Get("sha256"),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Payloads.List(filter))
+}
+
+// getPayloadHandler returns the metadata of a single payload, looked up by ID or SHA-256
+func getPayloadHandler(w http.ResponseWriter, r *http.Request) {
+	ref := chi.URLParam(r, "ref")
+
+	payload, exists := Payloads.Get(ref)
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Unknown payload: %s", ref))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payload)
+}
@@ -0,0 +1,133 @@
+package control
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/storage"
+)
+
+// DB is where the registry, job store and queues write their state through to
+var DB storage.Store = storage.NewNopStore()
+
+// UseStore switches persistence over to store and restores the agents, jobs and queues it holds
+func UseStore(store storage.Store) error {
+	agents, err := store.LoadAgents()
+	if err != nil {
+		return fmt.Errorf("loading agents: %w", err)
+	}
+
+	jobs, err := store.LoadJobs()
+	if err != nil {
+		return fmt.Errorf("loading jobs: %w", err)
+	}
+
+	keys, err := store.LoadSessionKeys()
+	if err != nil {
+		return fmt.Errorf("loading session keys: %w", err)
+	}
+
+	DB = store
+
+	Agents.restore(agents, keys)
+	Jobs.restore(jobs)
+	AgentCommands.restore(jobs)
+
+	log.Printf("Restored %d agents and %d jobs from storage", len(agents), len(jobs))
+
+	return nil
+}
+
+// persistAgent writes an agent through to storage, failures are logged but never block the caller
+func persistAgent(agent models.Agent) {
+	if err := DB.SaveAgent(agent); err != nil {
+		log.Printf("ERROR: Failed to persist agent %s: %v", agent.ID, err)
+	}
+}
+
+// persistSessionKey writes an agent's session key through to storage, failures are logged but never block the caller
+func persistSessionKey(agentID string, key []byte) {
+	if err := DB.SaveSessionKey(agentID, key); err != nil {
+		log.Printf("ERROR: Failed to persist session key for agent %s: %v", agentID, err)
+	}
+}
+
+// persistJob writes a job through to storage, failures are logged but never block the caller
+func persistJob(job models.Job) {
+	if err := DB.SaveJob(job); err != nil {
+		log.Printf("ERROR: Failed to persist job %s: %v", job.ID, err)
+	}
+}
+
+// restore replaces the registry contents with agents and session keys loaded from storage
+func (ar *AgentRegistry) restore(agents []models.Agent, keys map[string][]byte) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	ar.agents = make(map[string]*models.Agent, len(agents))
+	ar.keys = make(map[string][]byte, len(keys))
+	ar.dirty = make(map[string]bool)
+	for i := range agents {
+		key, ok := keys[agents[i].ID]
+		if !ok {
+			// Without a key nothing can be exchanged, the agent will register again on its next check-in
+			log.Printf("WARN: No session key stored for agent %s, dropping it", agents[i].ID)
+			continue
+		}
+		ar.agents[agents[i].ID] = &agents[i]
+		ar.keys[agents[i].ID] = key
+	}
+}
+
+// restore replaces the store contents with jobs loaded from storage and continues numbering after the highest ID
+func (js *JobStore) restore(jobs []models.Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	js.jobs = make(map[string]*models.Job, len(jobs))
+	js.recurring = make(map[string]map[string]*models.Job)
+	js.unflushed = make(map[string]bool)
+	js.broadcasts = make(map[string][]*models.Job)
+	js.nextID = 0
+	for i := range jobs {
+		js.jobs[jobs[i].ID] = &jobs[i]
+		js.indexRecurring(&jobs[i], jobs[i].AgentID)
+		js.nextID = max(js.nextID, jobNumber(jobs[i].ID))
+	}
+
+	// Broadcasts still running pick up their jobs again once every job is loaded
+	for _, job := range js.jobs {
+		if parent, ok := js.jobs[job.ParentID]; ok && parent.Status == models.JobBroadcast {
+			js.broadcasts[parent.ID] = append(js.broadcasts[parent.ID], job)
+		}
+	}
+}
+
+// restore rebuilds every agent's queue from the jobs that were still waiting to be dispatched
+func (cq *CommandQueue) restore(jobs []models.Job) {
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	queued := make([]models.Job, 0)
+	for _, job := range jobs {
+		if job.Status == models.JobQueued {
+			queued = append(queued, job)
+		}
+	}
+
+	sort.Slice(queued, func(i, j int) bool {
+		if queued[i].Priority != queued[j].Priority {
+			return queued[i].Priority > queued[j].Priority
+		}
+		if !queued[i].QueuedAt.Equal(queued[j].QueuedAt) {
+			return queued[i].QueuedAt.Before(queued[j].QueuedAt)
+		}
+		return queued[i].ID < queued[j].ID
+	})
+
+	cq.PendingCommands = make(map[string][]string)
+	for _, job := range queued {
+		cq.PendingCommands[job.AgentID] = append(cq.PendingCommands[job.AgentID], job.ID)
+	}
+}
@@ -0,0 +1,94 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"workshop3_dev/internals/audit"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/pki"
+	"workshop3_dev/internals/signing"
+)
+
+// PKI is the local certificate authority, set by main before the API starts
+var PKI *pki.Authority
+
+// Signer signs every job handed to an agent, set by main before the API starts
+var Signer *signing.Signer
+
+// pkiInfoHandler returns the CA certificate and fingerprints that agents pin against
+func pkiInfoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PKI.Info())
+}
+
+// signingKeyHandler returns the public key that agents must be built with to accept jobs
+func signingKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.SigningKeyResponse{PublicKey: Signer.PublicKey()})
+}
+
+// rotateCertificateHandler issues a new listener certificate from the same CA
+func rotateCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	op, _ := OperatorFromContext(r.Context())
+
+	info, err := PKI.Rotate()
+	if err != nil {
+		var message = fmt.Sprintf("ERROR: Rotating certificate failed: %v", err)
+		log.Printf(message)
+		audit.Record(audit.Entry{
+			Action:   "certificate_rotate",
+			Operator: op.Name,
+			SourceIP: audit.SourceIP(r.RemoteAddr),
+			Outcome:  message,
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(message)
+		return
+	}
+
+	audit.Record(audit.Entry{
+		Action:   "certificate_rotate",
+		Operator: op.Name,
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		Outcome:  fmt.Sprintf("new leaf %s", info.LeafFingerprint),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// issueClientCertHandler issues a client certificate for agents connecting to mutual TLS listeners
+func issueClientCertHandler(w http.ResponseWriter, r *http.Request) {
+	op, _ := OperatorFromContext(r.Context())
+
+	var req models.ClientCertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode("ERROR: a name for the certificate is required")
+		return
+	}
+
+	certPEM, keyPEM, err := PKI.IssueClientCert(req.Name)
+	if err != nil {
+		var message = fmt.Sprintf("ERROR: Issuing client certificate failed: %v", err)
+		log.Printf(message)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(message)
+		return
+	}
+
+	audit.Record(audit.Entry{
+		Action:   "client_cert_issued",
+		Operator: op.Name,
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		Outcome:  req.Name,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ClientCertResponse{
+		CertPEM: string(certPEM),
+		KeyPEM:  string(keyPEM),
+	})
+}
@@ -0,0 +1,149 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"workshop3_dev/internals/audit"
+	"workshop3_dev/internals/events"
+	"workshop3_dev/internals/models"
+)
+
+// listQueuesHandler returns the waiting jobs of every agent, in dispatch order
+func listQueuesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AgentCommands.PendingAll())
+}
+
+// getAgentQueueHandler returns the jobs waiting for a single agent, in dispatch order
+func getAgentQueueHandler(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "id")
+
+	if _, exists := Agents.Get(agentID); !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Unknown agent: %s", agentID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AgentCommands.Pending(agentID))
+}
+
+// cancelJobHandler cancels a job that has not been picked up yet
+func cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := authorizeJobChange(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := AgentCommands.Cancel(job.ID)
+	finishJobChange(w, r, "job_cancelled", models.EventJobCancelled, job, err, "cancelled")
+}
+
+// setPriorityHandler changes where a queued job sits in its agent's queue
+func setPriorityHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := authorizeJobChange(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.PriorityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Invalid request: %v", err))
+		return
+	}
+
+	job, err := AgentCommands.SetPriority(job.ID, req.Priority)
+	finishJobChange(w, r, "job_reprioritised", models.EventJobUpdated, job, err, fmt.Sprintf("priority %d", req.Priority))
+}
+
+// moveJobHandler hands a queued job to another agent
+func moveJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := authorizeJobChange(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Invalid request: %v", err))
+		return
+	}
+
+	if _, exists := Agents.Get(req.AgentID); !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Unknown agent: %s", req.AgentID))
+		return
+	}
+
+	previousAgent := job.AgentID
+	job, err := AgentCommands.Move(job.ID, req.AgentID)
+	finishJobChange(w, r, "job_moved", models.EventJobUpdated, job, err, fmt.Sprintf("moved from %s", previousAgent))
+}
+
+// authorizeJobChange looks up the job in the URL and checks the operator may run its command
+func authorizeJobChange(w http.ResponseWriter, r *http.Request) (models.Job, bool) {
+	op, _ := OperatorFromContext(r.Context())
+	jobID := chi.URLParam(r, "id")
+
+	job, exists := Jobs.Get(jobID)
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Unknown job: %s", jobID))
+		return models.Job{}, false
+	}
+
+	if !op.CanRun(job.Command) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: Operator %s is not allowed to change '%s' jobs", op.Name, job.Command))
+		return models.Job{}, false
+	}
+
+	return job, true
+}
+
+// finishJobChange reports the outcome of a queue change to the client, and audits and publishes it when it succeeded
+func finishJobChange(w http.ResponseWriter, r *http.Request, action string, eventType models.EventType, job models.Job, err error, outcome string) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrJobNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, ErrJobNotQueued):
+			status = http.StatusConflict
+		}
+		log.Printf("ERROR: %s failed: %v", action, err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(fmt.Sprintf("ERROR: %v", err))
+		return
+	}
+
+	op, _ := OperatorFromContext(r.Context())
+
+	audit.Record(audit.Entry{
+		Action:   action,
+		Operator: op.Name,
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		AgentID:  job.AgentID,
+		JobID:    job.ID,
+		Command:  job.Command,
+		Outcome:  outcome,
+	})
+
+	events.Publish(models.Event{
+		Type:    eventType,
+		AgentID: job.AgentID,
+		JobID:   job.ID,
+		Command: job.Command,
+		Status:  string(job.Status),
+		Detail:  outcome + " by " + op.Name,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
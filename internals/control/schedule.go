@@ -0,0 +1,292 @@
+package control
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"workshop3_dev/internals/events"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/schedule"
+)
+
+// schedulerInterval is how often cron schedules and dispatch windows are checked between agent check-ins
+const schedulerInterval = 10 * time.Second
+
+// validateSchedule checks the dispatch window and recurrence an operator asked for
+func validateSchedule(cmd models.CommandClient, now time.Time) error {
+	if cmd.NotAfter != nil {
+		if !cmd.NotAfter.After(now) {
+			return fmt.Errorf("not_after %s is already in the past", cmd.NotAfter.Format(time.RFC3339))
+		}
+		if cmd.NotBefore != nil && !cmd.NotAfter.After(*cmd.NotBefore) {
+			return fmt.Errorf("not_after must be later than not_before")
+		}
+	}
+
+	rec := cmd.Recurrence
+	if rec == nil {
+		return nil
+	}
+
+	if rec.EveryCheckIns < 0 || rec.MaxRuns < 0 {
+		return fmt.Errorf("every_checkins and max_runs cannot be negative")
+	}
+	if (rec.EveryCheckIns > 0) == (rec.Cron != "") {
+		return fmt.Errorf("recurrence needs exactly one of every_checkins or cron")
+	}
+	if rec.Cron != "" {
+		if _, err := schedule.ParseCron(rec.Cron); err != nil {
+			return fmt.Errorf("cron: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// nextCronRun returns when a cron schedule is next due, counting from the later of after and the job's not_before
+func nextCronRun(job *models.Job, after time.Time) *time.Time {
+	cron, err := schedule.ParseCron(job.Recurrence.Cron)
+	if err != nil {
+		// Validated when the job was queued, so this only happens to a corrupt stored job
+		log.Printf("ERROR: Recurring job %s has an invalid cron schedule: %v", job.ID, err)
+		return nil
+	}
+
+	if job.NotBefore != nil && job.NotBefore.After(after) {
+		// Next is strictly after its argument, step back so a run exactly at not_before still counts
+		after = job.NotBefore.Add(-time.Minute)
+	}
+
+	next := cron.Next(after)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// expired reports whether a job's dispatch window has closed
+func expired(job *models.Job, now time.Time) bool {
+	return job.NotAfter != nil && now.After(*job.NotAfter)
+}
+
+// Expire marks a queued job or a recurring schedule whose not_after has passed as expired
+func (js *JobStore) Expire(jobID string, now time.Time) (models.Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	job, exists := js.jobs[jobID]
+	if !exists || (job.Status != models.JobQueued && job.Status != models.JobRecurring) || !expired(job, now) {
+		return models.Job{}, false
+	}
+
+	job.Status = models.JobExpired
+	job.CompletedAt = &now
+	job.NextRunAt = nil
+	js.indexRecurring(job, job.AgentID)
+	persistJob(*job)
+	js.finishBroadcast(job)
+
+	return *job, true
+}
+
+// indexRecurring keeps the index of recurring jobs in step with a job that may have changed status, or moved
+// from previousAgent to another agent, caller holds mu
+func (js *JobStore) indexRecurring(job *models.Job, previousAgent string) {
+	if jobs, ok := js.recurring[previousAgent]; ok {
+		delete(jobs, job.ID)
+		if len(jobs) == 0 {
+			delete(js.recurring, previousAgent)
+		}
+	}
+
+	if job.Status != models.JobRecurring {
+		delete(js.unflushed, job.ID)
+		return
+	}
+	if js.recurring[job.AgentID] == nil {
+		js.recurring[job.AgentID] = make(map[string]*models.Job)
+	}
+	js.recurring[job.AgentID][job.ID] = job
+}
+
+// flushCheckInCounts writes every recurring job whose check-in count changed since the last flush to storage
+func (js *JobStore) flushCheckInCounts() {
+	js.mu.Lock()
+	jobs := make([]models.Job, 0, len(js.unflushed))
+	for jobID := range js.unflushed {
+		if job, ok := js.jobs[jobID]; ok {
+			jobs = append(jobs, *job)
+		}
+	}
+	clear(js.unflushed)
+	js.mu.Unlock()
+
+	for _, job := range jobs {
+		persistJob(job)
+	}
+}
+
+// dueRuns creates a queued run of every recurring job that is due. A check-in from checkInAgent counts towards
+// that agent's every_checkins schedules, and only that agent's schedules are looked at; without one every
+// schedule is. Recurring jobs past their not_after are returned as expired.
+// Check-in counts are only kept in memory until the next flush, a job is written to storage when a run is created.
+func (js *JobStore) dueRuns(now time.Time, checkInAgent string) (runs []models.Job, expiredJobs []models.Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	var candidates []*models.Job
+	if checkInAgent != "" {
+		for _, job := range js.recurring[checkInAgent] {
+			candidates = append(candidates, job)
+		}
+	} else {
+		for _, jobs := range js.recurring {
+			for _, job := range jobs {
+				candidates = append(candidates, job)
+			}
+		}
+	}
+
+	for _, job := range candidates {
+		if expired(job, now) {
+			job.Status = models.JobExpired
+			job.CompletedAt = &now
+			job.NextRunAt = nil
+			js.indexRecurring(job, job.AgentID)
+			persistJob(*job)
+			expiredJobs = append(expiredJobs, *job)
+			continue
+		}
+
+		if job.NotBefore != nil && now.Before(*job.NotBefore) {
+			continue
+		}
+
+		var due bool
+		if job.Recurrence.EveryCheckIns > 0 {
+			if job.AgentID != checkInAgent {
+				continue
+			}
+			job.CheckInsSinceRun++
+			due = job.CheckInsSinceRun >= job.Recurrence.EveryCheckIns
+		} else {
+			due = job.NextRunAt != nil && !now.Before(*job.NextRunAt)
+		}
+
+		if !due {
+			if job.Recurrence.EveryCheckIns > 0 {
+				js.unflushed[job.ID] = true
+			}
+			continue
+		}
+
+		job.CheckInsSinceRun = 0
+		if job.Recurrence.Cron != "" {
+			job.NextRunAt = nextCronRun(job, now)
+		}
+
+		// Runs never pile up, if the previous one has not been picked up yet this one is skipped
+		if previous, ok := js.jobs[job.LastRunID]; ok && previous.Status == models.JobQueued {
+			log.Printf("Skipping run of recurring job %s, %s is still queued", job.ID, previous.ID)
+			if job.Recurrence.EveryCheckIns > 0 {
+				js.unflushed[job.ID] = true
+			} else {
+				// At most once per cron period, and the next run time has to survive a restart
+				persistJob(*job)
+			}
+			continue
+		}
+
+		js.nextID++
+		run := &models.Job{
+			ID:        fmt.Sprintf("job_%06d", js.nextID),
+			AgentID:   job.AgentID,
+			Command:   job.Command,
+			Arguments: job.Arguments,
+			Status:    models.JobQueued,
+			Priority:  job.Priority,
+			QueuedAt:  now,
+			NotAfter:  job.NotAfter,
+			ParentID:  job.ID,
+		}
+		js.jobs[run.ID] = run
+		persistJob(*run)
+		runs = append(runs, *run)
+
+		job.Runs++
+		job.LastRunID = run.ID
+		if job.Recurrence.MaxRuns > 0 && job.Runs >= job.Recurrence.MaxRuns {
+			job.Status = models.JobCompleted
+			job.CompletedAt = &now
+			job.NextRunAt = nil
+		}
+		js.indexRecurring(job, job.AgentID)
+		delete(js.unflushed, job.ID)
+		persistJob(*job)
+	}
+
+	return runs, expiredJobs
+}
+
+// runDue queues every recurring run that is due, caller holds mu
+func (cq *CommandQueue) runDue(now time.Time, checkInAgent string) {
+	runs, expiredJobs := Jobs.dueRuns(now, checkInAgent)
+
+	for _, run := range runs {
+		cq.insert(run)
+		log.Printf("QUEUED: %s as %s for agent %s, a run of %s", run.Command, run.ID, run.AgentID, run.ParentID)
+		events.Publish(models.Event{
+			Type:     models.EventJobQueued,
+			AgentID:  run.AgentID,
+			JobID:    run.ID,
+			ParentID: run.ParentID,
+			Command:  run.Command,
+			Detail:   "run of " + run.ParentID,
+		})
+	}
+
+	for _, job := range expiredJobs {
+		announceExpired(job)
+	}
+}
+
+// expireOverdue takes every job whose window has closed out of an agent's queue, caller holds mu
+func (cq *CommandQueue) expireOverdue(agentID string, now time.Time) {
+	for _, jobID := range append([]string(nil), cq.PendingCommands[agentID]...) {
+		if job, ok := Jobs.Expire(jobID, now); ok {
+			cq.remove(agentID, jobID)
+			announceExpired(job)
+		}
+	}
+}
+
+// announceExpired logs and publishes a job that expired before it could run
+func announceExpired(job models.Job) {
+	log.Printf("EXPIRED: %s (%s) for agent %s, not_after %s passed", job.ID, job.Command, job.AgentID, job.NotAfter.Format(time.RFC3339))
+	events.Publish(models.Event{
+		Type:     models.EventJobExpired,
+		AgentID:  job.AgentID,
+		JobID:    job.ID,
+		ParentID: job.ParentID,
+		Command:  job.Command,
+		Status:   string(job.Status),
+	})
+}
+
+// StartScheduler periodically queues due cron runs and expires jobs whose window closed while their agent was away
+func StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			AgentCommands.mu.Lock()
+			now := time.Now()
+			AgentCommands.runDue(now, "")
+			for agentID := range AgentCommands.PendingCommands {
+				AgentCommands.expireOverdue(agentID, now)
+			}
+			AgentCommands.mu.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,133 @@
+package control
+
+import (
+	"sync"
+	"testing"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/storage"
+)
+
+// countingStore keeps nothing but counts how often each job is written
+type countingStore struct {
+	storage.Store
+	saves map[string]int
+	mu    sync.Mutex
+}
+
+func (cs *countingStore) SaveJob(job models.Job) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.saves[job.ID]++
+	return nil
+}
+
+func (cs *countingStore) count(jobID string) int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.saves[jobID]
+}
+
+// useCountingStore starts the job store and queues empty, writing through to a countingStore
+func useCountingStore(t *testing.T) *countingStore {
+	t.Helper()
+
+	store := &countingStore{Store: storage.NewNopStore(), saves: make(map[string]int)}
+	DB = store
+	Jobs.restore(nil)
+	AgentCommands.restore(nil)
+	t.Cleanup(func() {
+		DB = storage.NewNopStore()
+		Jobs.restore(nil)
+		AgentCommands.restore(nil)
+	})
+
+	return store
+}
+
+// checkIn hands out whatever the agent has queued, the way a check-in does
+func checkIn(t *testing.T, agentID string) (models.Job, bool) {
+	t.Helper()
+	job, ok, err := AgentCommands.GetCommand(agentID, func(models.Job) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job, ok
+}
+
+func TestEveryCheckInsOnlyPersistsRuns(t *testing.T) {
+	store := useCountingStore(t)
+
+	recurring := Jobs.Create(models.CommandClient{
+		AgentID:    "agent_a",
+		Command:    "shellcode",
+		Recurrence: &models.Recurrence{EveryCheckIns: 3},
+	})
+	other := Jobs.Create(models.CommandClient{
+		AgentID:    "agent_b",
+		Command:    "shellcode",
+		Recurrence: &models.Recurrence{EveryCheckIns: 1},
+	})
+	created := store.count(recurring.ID)
+
+	var runs []string
+	for i := 0; i < 7; i++ {
+		if run, ok := checkIn(t, "agent_a"); ok {
+			runs = append(runs, run.ID)
+		}
+	}
+
+	// Runs are queued on the 3rd and 6th check-in and handed out straight away
+	if len(runs) != 2 {
+		t.Fatalf("got %d runs from 7 check-ins, want 2", len(runs))
+	}
+	if saves := store.count(recurring.ID) - created; saves != 2 {
+		t.Errorf("recurring job written %d times over 7 check-ins, want once per run (2)", saves)
+	}
+
+	job, _ := Jobs.Get(recurring.ID)
+	if job.CheckInsSinceRun != 1 || job.Runs != 2 {
+		t.Errorf("check-ins since run %d and runs %d, want 1 and 2", job.CheckInsSinceRun, job.Runs)
+	}
+
+	// Another agent's check-ins leave the schedule alone
+	if job, _ := Jobs.Get(other.ID); job.CheckInsSinceRun != 0 || job.Runs != 0 {
+		t.Errorf("agent_b's job counted agent_a's check-ins: %+v", job)
+	}
+
+	// The count in memory is written on the next flush, and only once
+	FlushCheckIns()
+	FlushCheckIns()
+	if saves := store.count(recurring.ID) - created; saves != 3 {
+		t.Errorf("recurring job written %d times after two flushes, want 3", saves)
+	}
+}
+
+func TestRecurringIndexFollowsMovesAndCancels(t *testing.T) {
+	useCountingStore(t)
+
+	job := Jobs.Create(models.CommandClient{
+		AgentID:    "agent_a",
+		Command:    "shellcode",
+		Recurrence: &models.Recurrence{EveryCheckIns: 1},
+	})
+
+	if _, err := AgentCommands.Move(job.ID, "agent_b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := checkIn(t, "agent_a"); ok {
+		t.Error("agent_a got a run of a job moved to agent_b")
+	}
+	if _, ok := checkIn(t, "agent_b"); !ok {
+		t.Error("agent_b got no run of the job moved to it")
+	}
+
+	if _, err := AgentCommands.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := checkIn(t, "agent_b"); ok {
+		t.Error("agent_b got a run of a cancelled job")
+	}
+	if n := len(Jobs.recurring); n != 0 {
+		t.Errorf("%d agents still indexed with recurring jobs after the only one was cancelled", n)
+	}
+}
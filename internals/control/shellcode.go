@@ -10,38 +10,58 @@ import (
 	"workshop3_dev/internals/models"
 )
 
-// validateShellcodeCommand validates "shellcode" command arguments from client
-func validateShellcodeCommand(rawArgs json.RawMessage) error {
+// validateShellcodeCommand validates "shellcode" command arguments from client, and checks the DLL they refer to
+// is one the agent can load. The report summarises the DLL's exports and imports, and the DLL itself is returned
+// for processShellcodeCommand.
+func validateShellcodeCommand(rawArgs json.RawMessage) (json.RawMessage, []byte, error) {
 	if len(rawArgs) == 0 {
-		return fmt.Errorf("load command requires arguments")
+		return nil, nil, fmt.Errorf("load command requires arguments")
 	}
 
 	var args models.ShellcodeArgsClient
 
 	if err := json.Unmarshal(rawArgs, &args); err != nil {
-		return fmt.Errorf("invalid argument format: %w", err)
+		return nil, nil, fmt.Errorf("invalid argument format: %w", err)
 	}
 
-	if args.FilePath == "" {
-		return fmt.Errorf("file_path is required")
+	if (args.Payload == "") == (args.FilePath == "") {
+		return nil, nil, fmt.Errorf("exactly one of payload or file_path is required")
 	}
 
 	if args.ExportName == "" {
-		return fmt.Errorf("export_name is required")
+		return nil, nil, fmt.Errorf("export_name is required")
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(args.FilePath); os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: %s", args.FilePath)
+	if args.Payload != "" {
+		if _, exists := Payloads.Get(args.Payload); !exists {
+			return nil, nil, fmt.Errorf("unknown payload: %s", args.Payload)
+		}
 	}
 
-	log.Printf("Validation passed: file_path=%s, export_name=%s", args.FilePath, args.ExportName)
+	// Reads from a payload root are confined by readShellcodeDLL
+	fileBytes, source, err := readShellcodeDLL(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report, err := inspectDLL(fileBytes, args.ExportName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshaling report: %w", err)
+	}
+
+	log.Printf("Validation passed: %s, export_name=%s, %d exports, %d imported libraries",
+		source, args.ExportName, len(report.Exports), len(report.Imports))
 
-	return nil
+	return reportJSON, fileBytes, nil
 }
 
-// processShellcodeCommand reads the DLL file and converts to base64 to create arguments sent to agent
-func processShellcodeCommand(rawArgs json.RawMessage) (json.RawMessage, error) {
+// processShellcodeCommand converts the DLL the validator read to base64 to create arguments sent to agent
+func processShellcodeCommand(rawArgs json.RawMessage, fileBytes []byte) (json.RawMessage, error) {
 
 	var clientArgs models.ShellcodeArgsClient
 
@@ -49,16 +69,8 @@ func processShellcodeCommand(rawArgs json.RawMessage) (json.RawMessage, error) {
 		return nil, fmt.Errorf("unmarshaling args: %w", err)
 	}
 
-	// Read the DLL file
-	file, err := os.Open(clientArgs.FilePath)
-	if err != nil {
-		return nil, fmt.Errorf("opening file: %w", err)
-	}
-	defer file.Close()
-
-	fileBytes, err := io.ReadAll(file)
-	if err != nil {
-		return nil, fmt.Errorf("reading file: %w", err)
+	if len(fileBytes) == 0 {
+		return nil, fmt.Errorf("no DLL was loaded during validation")
 	}
 
 	// Convert to base64
@@ -78,7 +90,47 @@ func processShellcodeCommand(rawArgs json.RawMessage) (json.RawMessage, error) {
 	}
 
 	log.Printf("Processed file: %s (%d bytes) -> base64 (%d chars)",
-		clientArgs.FilePath, len(fileBytes), len(shellcodeB64))
+		clientArgs.Payload+clientArgs.FilePath, len(fileBytes), len(shellcodeB64))
 
 	return processedJSON, nil
 }
+
+// readShellcodeDLL returns the DLL the arguments refer to, along with a description of where it came from
+func readShellcodeDLL(args models.ShellcodeArgsClient) ([]byte, string, error) {
+	if args.Payload != "" {
+		data, payload, err := Payloads.Read(args.Payload)
+		if err != nil {
+			return nil, "", err
+		}
+		return data, fmt.Sprintf("%s (%s, sha256 %s)", payload.ID, payload.Name, payload.SHA256), nil
+	}
+
+	// The path is resolved and the file read in one go, what is checked is what gets sent
+	path, err := resolvePayloadPath(args.FilePath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Read the DLL file
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+
+	// Refuse a file that was swapped for a symlink or another file after it was resolved
+	opened, err := file.Stat()
+	if err != nil {
+		return nil, "", fmt.Errorf("opening file: %w", err)
+	}
+	if current, err := os.Lstat(path); err != nil || !os.SameFile(opened, current) {
+		return nil, "", fmt.Errorf("%w: %s changed while it was being opened", ErrPathNotAllowed, args.FilePath)
+	}
+
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading file: %w", err)
+	}
+
+	return fileBytes, path, nil
+}
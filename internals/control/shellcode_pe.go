@@ -0,0 +1,85 @@
+package control
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/pemap"
+)
+
+const maxExportsInError = 20 // Longer export lists are cut short in validation errors
+
+// inspectDLL checks a DLL is something the agent's loader can map and call exportName in, and summarises it.
+// The loader only handles x64 PE32+ DLLs, has to rebase them, and looks the export up by name.
+// The checks use pemap, the loader's own parser, so what passes here is what the agent can load.
+func inspectDLL(data []byte, exportName string) (models.DLLReport, error) {
+	image, err := pemap.Parse(data)
+	if err != nil {
+		return models.DLLReport{}, fmt.Errorf("not a DLL the agent can load: %w", err)
+	}
+
+	if image.Machine() != pe.IMAGE_FILE_MACHINE_AMD64 {
+		return models.DLLReport{}, fmt.Errorf("DLL is built for machine type 0x%x, the agent only loads x64 (0x%x) DLLs", image.Machine(), pe.IMAGE_FILE_MACHINE_AMD64)
+	}
+
+	if !image.IsDLL() {
+		return models.DLLReport{}, fmt.Errorf("file is an executable, not a DLL (IMAGE_FILE_DLL is not set)")
+	}
+
+	if !image.Relocatable() {
+		return models.DLLReport{}, fmt.Errorf("DLL has no relocation directory, so the agent cannot load it away from its preferred base")
+	}
+
+	exports, err := image.Exports()
+	if err != nil {
+		return models.DLLReport{}, fmt.Errorf("reading export table: %w", err)
+	}
+	if !slices.Contains(exports, exportName) {
+		return models.DLLReport{}, fmt.Errorf("export %q not found, %s", exportName, describeExports(exports))
+	}
+
+	// pemap only resolves imports while mapping, debug/pe lists them without needing the memory to
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return models.DLLReport{}, fmt.Errorf("not a valid PE file: %w", err)
+	}
+	defer f.Close()
+
+	symbols, err := f.ImportedSymbols()
+	if err != nil {
+		return models.DLLReport{}, fmt.Errorf("reading import table: %w", err)
+	}
+	imports := make(map[string][]string)
+	for _, symbol := range symbols {
+		// debug/pe gives "function:library", functions imported by ordinal are left out
+		function, library, _ := strings.Cut(symbol, ":")
+		imports[library] = append(imports[library], function)
+	}
+
+	sum := sha256.Sum256(data)
+	return models.DLLReport{
+		SHA256:      hex.EncodeToString(sum[:]),
+		Size:        len(data),
+		ImageBase:   image.ImageBase(),
+		SizeOfImage: image.SizeOfImage(),
+		Exports:     exports,
+		Imports:     imports,
+	}, nil
+}
+
+// describeExports lists what a DLL does export, for an error about what it doesn't
+func describeExports(exports []string) string {
+	switch {
+	case len(exports) == 0:
+		return "the DLL exports no functions by name"
+	case len(exports) > maxExportsInError:
+		return fmt.Sprintf("the DLL exports %s and %d more", strings.Join(exports[:maxExportsInError], ", "), len(exports)-maxExportsInError)
+	default:
+		return "the DLL exports " + strings.Join(exports, ", ")
+	}
+}
@@ -0,0 +1,72 @@
+package control
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// peFixture reads one of the pemap test DLLs, described by internals/pemap/testdata/gen.go and real/build.sh
+func peFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "pemap", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestInspectDLL(t *testing.T) {
+	report, err := inspectDLL(peFixture(t, "real.dll"), "Run")
+	if err != nil {
+		t.Fatalf("inspectDLL rejected a loadable DLL: %v", err)
+	}
+	if !slices.Equal(report.Exports, []string{"Fwd", "Other", "Run"}) {
+		t.Errorf("exports = %v, want [Fwd Other Run]", report.Exports)
+	}
+	if !slices.Equal(report.Imports["KERNEL32.dll"], []string{"GetCurrentProcessId", "Sleep"}) || len(report.Imports) != 1 {
+		t.Errorf("imports = %v, want KERNEL32.dll!GetCurrentProcessId and KERNEL32.dll!Sleep", report.Imports)
+	}
+	if report.ImageBase != 0x180000000 || report.SizeOfImage != 0x6000 {
+		t.Errorf("image base 0x%x and size 0x%x, want 0x180000000 and 0x6000", report.ImageBase, report.SizeOfImage)
+	}
+}
+
+func TestInspectDLLRejects(t *testing.T) {
+	// real.dll relabelled as an ARM64 image, everything else about it loadable
+	arm64 := slices.Clone(peFixture(t, "real.dll"))
+	binary.LittleEndian.PutUint16(arm64[binary.LittleEndian.Uint32(arm64[0x3c:])+4:], 0xaa64)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		export string
+		want   string // Part of the error
+	}{
+		{"PE32", peFixture(t, "real32.dll"), "Run", "only PE32+"},
+		{"ARM64", arm64, "Run", "only loads x64"},
+		{"not a DLL", peFixture(t, "real.exe"), "Run", "not a DLL"},
+		{"no relocations", peFixture(t, "norel.dll"), "Run", "no relocation directory"},
+		{"missing named export", peFixture(t, "real.dll"), "Missing", `export "Missing" not found, the DLL exports Fwd, Other, Run`},
+		{"name count overflowing 32 bits", peFixture(t, "hugenames.dll"), "Run", "runs past the end of its section"},
+		{"export directory out of bounds", peFixture(t, "badexport.dll"), "Run", "reading export table"},
+		{"truncated headers", peFixture(t, "truncatedheaders.dll"), "Run", "not a DLL the agent can load"},
+		{"truncated", peFixture(t, "real.dll")[:0x100], "Run", "not a DLL the agent can load"},
+		{"not a PE file", []byte("MZ"), "Run", "not a DLL the agent can load"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := inspectDLL(tt.data, tt.export)
+			if err == nil {
+				t.Fatal("inspectDLL accepted the DLL")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
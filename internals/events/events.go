@@ -0,0 +1,129 @@
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+	"workshop3_dev/internals/models"
+)
+
+const (
+	historySize      = 256 // Recent events kept so a reconnecting subscriber can catch up
+	subscriberBuffer = 64  // Events a subscriber may fall behind by before it is cut off
+)
+
+// subscriber is one Subscribe call, receiving only the event types it asked for
+type subscriber struct {
+	types map[models.EventType]bool // nil receives every type
+}
+
+func (s subscriber) wants(event models.Event) bool {
+	return s.types == nil || s.types[event.Type]
+}
+
+// Bus fans events out to every subscriber without ever blocking the publisher
+type Bus struct {
+	subscribers map[chan models.Event]subscriber
+	history     []models.Event
+	nextID      uint64
+	mu          sync.Mutex
+}
+
+// NewBus creates an empty Bus
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[chan models.Event]subscriber),
+		history:     make([]models.Event, 0, historySize),
+	}
+}
+
+// Publish numbers and timestamps an event and hands it to every subscriber that wants its type
+func (b *Bus) Publish(event models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event.ID = b.nextID
+	event.Time = time.Now().UTC()
+
+	if len(b.history) == historySize {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	b.history = append(b.history, event)
+
+	for ch, sub := range b.subscribers {
+		if !sub.wants(event) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			// A slow subscriber must not hold up agent traffic. Closing its channel tells it there is a gap, so it
+			// can subscribe again after the last event it got and catch up from history.
+			log.Printf("WARN: Event subscriber fell %d events behind, closing its stream at event %d", subscriberBuffer, event.ID)
+			close(ch)
+			delete(b.subscribers, ch)
+		}
+	}
+}
+
+// Subscribe returns the events published after afterID that are still in history, and a channel for new ones,
+// both only of the given types (every type when none are given). The channel is closed if the subscriber falls
+// too far behind, it should then subscribe again after the last event it received.
+// The returned function must be called to unsubscribe.
+func (b *Bus) Subscribe(afterID uint64, types ...models.EventType) ([]models.Event, <-chan models.Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var sub subscriber
+	if len(types) > 0 {
+		sub.types = make(map[models.EventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	backlog := make([]models.Event, 0)
+	for _, event := range b.history {
+		if event.ID > afterID && sub.wants(event) {
+			backlog = append(backlog, event)
+		}
+	}
+
+	ch := make(chan models.Event, subscriberBuffer)
+	b.subscribers[ch] = sub
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, ch)
+	}
+
+	return backlog, ch, unsubscribe
+}
+
+// LastID returns the ID of the most recently published event
+func (b *Bus) LastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.nextID
+}
+
+// std is the bus used by the package-level functions
+var std = NewBus()
+
+// Publish publishes event on the default bus
+func Publish(event models.Event) {
+	std.Publish(event)
+}
+
+// Subscribe subscribes to the default bus
+func Subscribe(afterID uint64, types ...models.EventType) ([]models.Event, <-chan models.Event, func()) {
+	return std.Subscribe(afterID, types...)
+}
+
+// LastID returns the ID of the last event on the default bus
+func LastID() uint64 {
+	return std.LastID()
+}
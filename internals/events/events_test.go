@@ -0,0 +1,68 @@
+package events
+
+import (
+	"testing"
+	"workshop3_dev/internals/models"
+)
+
+func TestSubscribeFiltersTypes(t *testing.T) {
+	b := NewBus()
+	b.Publish(models.Event{Type: models.EventAgentCheckIn})
+	b.Publish(models.Event{Type: models.EventResultReceived, JobID: "job_000001"})
+
+	backlog, stream, unsubscribe := b.Subscribe(0, models.EventResultReceived)
+	defer unsubscribe()
+
+	if len(backlog) != 1 || backlog[0].JobID != "job_000001" {
+		t.Errorf("backlog = %+v, want only the result", backlog)
+	}
+
+	// Check-ins the subscriber did not ask for must not use up its buffer
+	for i := 0; i < subscriberBuffer*2; i++ {
+		b.Publish(models.Event{Type: models.EventAgentCheckIn})
+	}
+	b.Publish(models.Event{Type: models.EventResultReceived, JobID: "job_000002"})
+
+	select {
+	case event, ok := <-stream:
+		if !ok {
+			t.Fatal("stream was closed by events of a type it did not subscribe to")
+		}
+		if event.JobID != "job_000002" {
+			t.Errorf("got %+v, want the second result", event)
+		}
+	default:
+		t.Fatal("the second result was not delivered")
+	}
+}
+
+func TestSlowSubscriberIsClosedAndCatchesUp(t *testing.T) {
+	b := NewBus()
+	_, stream, unsubscribe := b.Subscribe(b.LastID())
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer+10; i++ {
+		b.Publish(models.Event{Type: models.EventResultReceived})
+	}
+
+	var lastID uint64
+	for event := range stream {
+		lastID = event.ID
+	}
+	if lastID != subscriberBuffer {
+		t.Fatalf("received up to event %d before the stream closed, want %d", lastID, subscriberBuffer)
+	}
+
+	// Subscribing again after the last event received fills the gap from history
+	backlog, _, resubscribe := b.Subscribe(lastID)
+	defer resubscribe()
+	if len(backlog) != 10 {
+		t.Fatalf("caught up on %d events, want 10", len(backlog))
+	}
+	if backlog[0].ID != lastID+1 {
+		t.Errorf("caught up from event %d, want %d", backlog[0].ID, lastID+1)
+	}
+
+	// Unsubscribing from a stream the bus already closed is harmless
+	unsubscribe()
+}
@@ -1,11 +1,41 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
+
+// AgentIDHeader is the HTTP header the Agent uses to identify itself on every request
+const AgentIDHeader = "X-Agent-ID"
+
+// CheckInHeader carries a sealed CheckIn, base64 encoded, proving a check-in comes from the holder of the session key
+const CheckInHeader = "X-Agent-CheckIn"
 
 // CommandClient represents a command with its arguments as sent by Client
 type CommandClient struct {
+	AgentID   string          `json:"agent_id,omitempty"`
+	Tag       string          `json:"tag,omitempty"` // Instead of AgentID, sends the command to every agent with this tag
 	Command   string          `json:"command"`
 	Arguments json.RawMessage `json:"data,omitempty"`
+	Priority  int             `json:"priority,omitempty"` // Higher priorities are dispatched first, default 0
+	// Optional window the job may be dispatched in, and a schedule to run it repeatedly
+	NotBefore  *time.Time  `json:"not_before,omitempty"`
+	NotAfter   *time.Time  `json:"not_after,omitempty"`
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+}
+
+// Recurrence makes a job run repeatedly, either on every N check-ins of its agent or on a cron schedule
+type Recurrence struct {
+	EveryCheckIns int    `json:"every_checkins,omitempty"`
+	Cron          string `json:"cron,omitempty"`     // minute hour day-of-month month day-of-week, server time zone
+	MaxRuns       int    `json:"max_runs,omitempty"` // 0 runs until not_after or until cancelled
+}
+
+// CheckIn is sealed by the Agent with its session key and sent with every check-in
+type CheckIn struct {
+	AgentID  string    `json:"agent_id"`
+	Nonce    string    `json:"nonce"`
+	IssuedAt time.Time `json:"issued_at"`
 }
 
 // ServerResponse represents a response from the server to the agent
@@ -14,21 +44,237 @@ type ServerResponse struct {
 	JobID     string          `json:"job_id,omitempty"`
 	Command   string          `json:"command,omitempty"`
 	Arguments json.RawMessage `json:"data,omitempty"`
+	// Jobs are signed with the engagement key, bound to one agent and only valid until ExpiresAt
+	AgentID   string    `json:"agent_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Signature []byte    `json:"signature,omitempty"`
+	// Every response is unique and timestamped so the agent can spot replays
+	Nonce    string    `json:"nonce"`
+	IssuedAt time.Time `json:"issued_at"`
 }
 
 type AgentTaskResult struct {
 	JobID         string          `json:"job_id"`
+	AgentID       string          `json:"agent_id"`
 	Success       bool            `json:"success"`
 	CommandResult json.RawMessage `json:"command_result,omitempty"`
-	Error         error           `json:"error,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	// Every submission is unique and timestamped so the server can spot replays
+	Nonce    string    `json:"nonce"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// JobStatus describes where a job is in its lifecycle
+type JobStatus string
+
+const (
+	JobQueued     JobStatus = "queued"
+	JobDispatched JobStatus = "dispatched"
+	JobCompleted  JobStatus = "completed"
+	JobFailed     JobStatus = "failed"
+	JobTimedOut   JobStatus = "timed_out"
+	JobCancelled  JobStatus = "cancelled"
+	JobExpired    JobStatus = "expired"   // not_after passed before the job could be dispatched
+	JobRecurring  JobStatus = "recurring" // A schedule that queues a new run of itself whenever it is due
+	JobBroadcast  JobStatus = "broadcast" // Fanned out into a job per tagged agent, completed, failed or cancelled once they all finish
+)
+
+// Job tracks a single command from the moment it is queued until its result comes back
+type Job struct {
+	ID           string           `json:"id"`
+	AgentID      string           `json:"agent_id"`
+	Command      string           `json:"command"`
+	Arguments    json.RawMessage  `json:"-"` // Processed arguments, can hold an entire DLL so never listed
+	Status       JobStatus        `json:"status"`
+	Priority     int              `json:"priority"`
+	QueuedAt     time.Time        `json:"queued_at"`
+	DispatchedAt *time.Time       `json:"dispatched_at,omitempty"`
+	CompletedAt  *time.Time       `json:"completed_at,omitempty"` // Set once a job can no longer change
+	Result       *AgentTaskResult `json:"result,omitempty"`
+	NotBefore    *time.Time       `json:"not_before,omitempty"`
+	NotAfter     *time.Time       `json:"not_after,omitempty"`
+	ParentID     string           `json:"parent_id,omitempty"` // The recurring job this is a run of, or the broadcast it is part of
+	Tag          string           `json:"tag,omitempty"`       // Only set on broadcasts
+	// Only set on recurring jobs
+	Recurrence       *Recurrence `json:"recurrence,omitempty"`
+	Runs             int         `json:"runs,omitempty"`
+	LastRunID        string      `json:"last_run_id,omitempty"`
+	NextRunAt        *time.Time  `json:"next_run_at,omitempty"` // Cron schedules only
+	CheckInsSinceRun int         `json:"checkins_since_run,omitempty"`
+}
+
+// JobDetails is a job together with the decoded output of its result
+type JobDetails struct {
+	Job
+	Output string `json:"output,omitempty"`
+	// The runs of a recurring job or the per-agent jobs of a broadcast, with a count of them by status
+	Children []JobDetails      `json:"children,omitempty"`
+	Summary  map[JobStatus]int `json:"summary,omitempty"`
+}
+
+// JobList is a single page of jobs matching a query
+type JobList struct {
+	Total  int   `json:"total"`
+	Offset int   `json:"offset"`
+	Limit  int   `json:"limit"`
+	Jobs   []Job `json:"jobs"`
+}
+
+// CommandInfo describes a command from the server's registry to operator tooling
+type CommandInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Arguments   []string `json:"arguments"`
+	MinRole     string   `json:"min_role"`
+}
+
+// PriorityRequest changes the priority of a queued job
+type PriorityRequest struct {
+	Priority int `json:"priority"`
+}
+
+// MoveRequest hands a queued job to another agent
+type MoveRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+// CommandResponse is returned to the Client once a command has been queued
+type CommandResponse struct {
+	JobID    string          `json:"job_id"`
+	Children []string        `json:"children,omitempty"` // Per-agent jobs of a broadcast
+	Report   json.RawMessage `json:"report,omitempty"`   // What validation found out about the arguments, if anything
+	Message  string          `json:"message"`
+}
+
+// RegisterRequest is sent by the Agent on first contact with the server
+type RegisterRequest struct {
+	AgentID   string `json:"agent_id,omitempty"` // Previously assigned ID, if the Agent has one
+	PublicKey []byte `json:"public_key"`         // Ephemeral X25519 key for the session key exchange
+	Hostname  string `json:"hostname"`
+	Username  string `json:"username"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	PID       int    `json:"pid"`
+	// Sleep settings let the server work out when the Agent is overdue
+	SleepSeconds float64 `json:"sleep_seconds"`
+	Jitter       int     `json:"jitter"`
+}
+
+// RegisterResponse contains the identity the server assigned to the Agent and its half of the key exchange
+type RegisterResponse struct {
+	AgentID   string `json:"agent_id"`
+	PublicKey []byte `json:"public_key"`
+	// Signed with the engagement key so nobody in the middle can swap in their own key
+	Signature []byte `json:"signature"`
+}
+
+// Envelope carries a ServerResponse or AgentTaskResult sealed with the agent's session key
+type Envelope struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Agent represents an implant known to the server
+type Agent struct {
+	ID           string    `json:"id"`
+	Hostname     string    `json:"hostname"`
+	Username     string    `json:"username"`
+	OS           string    `json:"os"`
+	Arch         string    `json:"arch"`
+	PID          int       `json:"pid"`
+	SleepSeconds float64   `json:"sleep_seconds"`
+	Jitter       int       `json:"jitter"`
+	RemoteAddr   string    `json:"remote_addr"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Tags         []string  `json:"tags,omitempty"`
+}
+
+// TagsRequest replaces the tags of an agent
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// AgentStatus describes whether an Agent is still checking in as expected
+type AgentStatus string
+
+const (
+	AgentActive AgentStatus = "active"
+	AgentStale  AgentStatus = "stale" // Missed several check-ins
+	AgentDead   AgentStatus = "dead"  // Missed so many check-ins it is unlikely to return
+)
+
+// AgentInfo is an Agent as listed by the control API
+type AgentInfo struct {
+	Agent
+	Status     AgentStatus `json:"status"`
+	QueueDepth int         `json:"queue_depth"`
+}
+
+// ListenerConfig describes a listener an operator wants to create
+type ListenerConfig struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"`            // "https" or "http"
+	CertFile string `json:"cert_file,omitempty"` // Leave both empty to use a certificate from the server's CA
+	KeyFile  string `json:"key_file,omitempty"`
+	// Only accept agents presenting a client certificate issued by the server's CA
+	RequireClientCert bool `json:"require_client_cert,omitempty"`
+}
+
+// Listener is a listener managed by the server
+type Listener struct {
+	ID string `json:"id"`
+	ListenerConfig
+	Running   bool      `json:"running"`
+	Error     string    `json:"error,omitempty"` // Why the listener last stopped unexpectedly
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ClientCertRequest asks the server's CA for an agent client certificate
+type ClientCertRequest struct {
+	Name string `json:"name"`
+}
+
+// ClientCertResponse holds a newly issued agent client certificate
+type ClientCertResponse struct {
+	CertPEM string `json:"cert_pem"`
+	KeyPEM  string `json:"key_pem"`
+}
+
+// SigningKeyResponse holds the public half of the engagement key agents verify jobs with
+type SigningKeyResponse struct {
+	PublicKey string `json:"public_key"`
 }
 
 // ShellcodeArgsClient contains the command-specific arguments for Shellcode Loader as sent by Client
 type ShellcodeArgsClient struct {
-	FilePath   string `json:"file_path"`
+	Payload    string `json:"payload,omitempty"`   // ID or SHA-256 of an uploaded payload
+	FilePath   string `json:"file_path,omitempty"` // Or a path on the server's disk
 	ExportName string `json:"export_name"`
 }
 
+// Payload describes a file operators uploaded to the server's payload library
+type Payload struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	SHA256     string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	Notes      string    `json:"notes,omitempty"`
+	UploadedBy string    `json:"uploaded_by"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// DLLReport summarises a DLL that passed validation, so the operator can check it before the task goes out
+type DLLReport struct {
+	SHA256      string              `json:"sha256"`
+	Size        int                 `json:"size"`
+	ImageBase   uint64              `json:"image_base"`
+	SizeOfImage uint32              `json:"size_of_image"`
+	Exports     []string            `json:"exports"`
+	Imports     map[string][]string `json:"imports"` // Imported functions by library
+}
+
 // ShellcodeArgsAgent contains the command-specific arguments for Shellcode Loader as sent to the Agent
 type ShellcodeArgsAgent struct {
 	ShellcodeBase64 string `json:"shellcode_base64"`
@@ -38,3 +284,46 @@ type ShellcodeArgsAgent struct {
 type ShellcodeResult struct {
 	Message string `json:"message"`
 }
+
+// Alert is a security event raised by the server, such as a replayed or tampered message
+type Alert struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	AgentID  string    `json:"agent_id,omitempty"`
+	JobID    string    `json:"job_id,omitempty"`
+	SourceIP string    `json:"source_ip,omitempty"`
+	Detail   string    `json:"detail"`
+}
+
+// EventType names something that happened on the server
+type EventType string
+
+const (
+	EventAgentRegistered   EventType = "agent_registered"
+	EventAgentCheckIn      EventType = "agent_checkin"
+	EventAgentStale        EventType = "agent_stale"
+	EventAgentDead         EventType = "agent_dead"
+	EventJobQueued         EventType = "job_queued"
+	EventJobDispatched     EventType = "job_dispatched"
+	EventResultReceived    EventType = "result_received"
+	EventJobTimedOut       EventType = "job_timed_out"
+	EventJobCancelled      EventType = "job_cancelled"
+	EventJobExpired        EventType = "job_expired"
+	EventJobUpdated        EventType = "job_updated"        // Priority changed or moved to another agent
+	EventBroadcastFinished EventType = "broadcast_finished" // The last per-agent job of a broadcast finished
+	EventAlert             EventType = "alert"
+)
+
+// Event is pushed to operators on the control API event stream as it happens
+type Event struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Type     EventType `json:"type"`
+	AgentID  string    `json:"agent_id,omitempty"`
+	JobID    string    `json:"job_id,omitempty"`
+	ParentID string    `json:"parent_id,omitempty"`
+	Command  string    `json:"command,omitempty"`
+	Status   string    `json:"status,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
@@ -0,0 +1,349 @@
+package operator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"workshop3_dev/internals/models"
+)
+
+// ClientConfig holds what is needed to reach and authenticate to the control API
+type ClientConfig struct {
+	BaseURL    string // e.g. http://127.0.0.1:8080 or https://127.0.0.1:8080
+	Token      string // Bearer token, may be empty when a client certificate is used
+	CAFile     string // CA that signed the control API certificate, when it serves TLS
+	ClientCert string // Operator certificate for mutual TLS
+	ClientKey  string
+}
+
+// Client talks to the control API on behalf of one operator
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+	stream  *http.Client // Same transport without the timeout, for the long lived event stream
+}
+
+// NewClient creates a Client for the control API described by cfg
+func NewClient(cfg ClientConfig) (*Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+
+	return &Client{
+		baseURL: strings.TrimRight(cfg.BaseURL, "/"),
+		token:   cfg.Token,
+		http: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: transport,
+		},
+		stream: &http.Client{Transport: transport},
+	}, nil
+}
+
+// Agents returns every agent known to the server
+func (c *Client) Agents() ([]models.AgentInfo, error) {
+	var agents []models.AgentInfo
+	err := c.do(http.MethodGet, "/agents", nil, &agents)
+	return agents, err
+}
+
+// Agent returns a single agent
+func (c *Client) Agent(agentID string) (models.AgentInfo, error) {
+	var agent models.AgentInfo
+	err := c.do(http.MethodGet, "/agents/"+url.PathEscape(agentID), nil, &agent)
+	return agent, err
+}
+
+// Commands returns the commands this operator is allowed to run
+func (c *Client) Commands() ([]models.CommandInfo, error) {
+	var commands []models.CommandInfo
+	err := c.do(http.MethodGet, "/commands", nil, &commands)
+	return commands, err
+}
+
+// RecentJobs returns up to limit of the newest jobs for an agent, or for every agent when agentID is empty
+func (c *Client) RecentJobs(agentID string, limit int) (models.JobList, error) {
+	query := url.Values{}
+	if agentID != "" {
+		query.Set("agent_id", agentID)
+	}
+	query.Set("limit", fmt.Sprint(limit))
+
+	var jobs models.JobList
+	if err := c.do(http.MethodGet, "/jobs?"+query.Encode(), nil, &jobs); err != nil {
+		return jobs, err
+	}
+
+	// Jobs are listed oldest first, so fetch the last page when there are more
+	if jobs.Total > limit {
+		query.Set("offset", fmt.Sprint(jobs.Total-limit))
+		err := c.do(http.MethodGet, "/jobs?"+query.Encode(), nil, &jobs)
+		return jobs, err
+	}
+
+	return jobs, nil
+}
+
+// Job returns a single job with its decoded output
+func (c *Client) Job(jobID string) (models.JobDetails, error) {
+	var job models.JobDetails
+	err := c.do(http.MethodGet, "/jobs/"+url.PathEscape(jobID), nil, &job)
+	return job, err
+}
+
+// Alerts returns the alerts raised after the given alert ID
+func (c *Client) Alerts(afterID uint64) ([]models.Alert, error) {
+	var alerts []models.Alert
+	err := c.do(http.MethodGet, fmt.Sprintf("/alerts?after=%d", afterID), nil, &alerts)
+	return alerts, err
+}
+
+// Task queues a command for an agent
+func (c *Client) Task(agentID string, command string, arguments json.RawMessage) (models.CommandResponse, error) {
+	body := models.CommandClient{
+		Command:   command,
+		Arguments: arguments,
+	}
+
+	var resp models.CommandResponse
+	err := c.do(http.MethodPost, "/agents/"+url.PathEscape(agentID)+"/command", body, &resp)
+	return resp, err
+}
+
+// Broadcast queues a command for every agent carrying a tag, under one parent job
+func (c *Client) Broadcast(tag string, command string, arguments json.RawMessage) (models.CommandResponse, error) {
+	body := models.CommandClient{
+		Command:   command,
+		Arguments: arguments,
+	}
+
+	var resp models.CommandResponse
+	err := c.do(http.MethodPost, "/tags/"+url.PathEscape(tag)+"/command", body, &resp)
+	return resp, err
+}
+
+// SetTags replaces the tags of an agent
+func (c *Client) SetTags(agentID string, tags []string) (models.AgentInfo, error) {
+	var agent models.AgentInfo
+	err := c.do(http.MethodPut, "/agents/"+url.PathEscape(agentID)+"/tags", models.TagsRequest{Tags: tags}, &agent)
+	return agent, err
+}
+
+// Payloads returns the payloads in the server's library
+func (c *Client) Payloads() ([]models.Payload, error) {
+	var payloads []models.Payload
+	err := c.do(http.MethodGet, "/payloads", nil, &payloads)
+	return payloads, err
+}
+
+// UploadPayload adds a local file to the server's payload library, name defaults to the file's base name
+func (c *Client) UploadPayload(path string, name string, notes string) (models.Payload, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return models.Payload{}, err
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if name != "" {
+		form.WriteField("name", name)
+	}
+	if notes != "" {
+		form.WriteField("notes", notes)
+	}
+	part, err := form.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return models.Payload{}, err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return models.Payload{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := form.Close(); err != nil {
+		return models.Payload{}, err
+	}
+
+	var payload models.Payload
+	err = c.send(http.MethodPost, "/payloads", form.FormDataContentType(), &body, &payload)
+	return payload, err
+}
+
+// StreamEvents follows the server's event stream, calling fn for every event of the given types (all when empty).
+// Events after afterID are replayed first when afterID is non-zero. It returns once ctx is done or the server
+// closes the stream, which it does when the client falls behind, with the event ID to pass back in to resume.
+func (c *Client) StreamEvents(ctx context.Context, afterID uint64, types []string, fn func(models.Event)) (uint64, error) {
+	query := url.Values{}
+	if len(types) > 0 {
+		query.Set("type", strings.Join(types, ","))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/events?"+query.Encode(), nil)
+	if err != nil {
+		return afterID, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if afterID > 0 {
+		req.Header.Set("Last-Event-ID", fmt.Sprint(afterID))
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return afterID, fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return afterID, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	// Each data line holds a complete JSON event. id lines, including the one the stream starts with, are
+	// where to resume from.
+	lastID := afterID
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+				lastID = n
+			}
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+
+		var event models.Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return lastID, fmt.Errorf("decoding event: %w", err)
+		}
+		fn(event)
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return lastID, fmt.Errorf("reading event stream: %w", err)
+	}
+	return lastID, nil
+}
+
+// Queue returns the jobs waiting for an agent, in the order they will be dispatched
+func (c *Client) Queue(agentID string) ([]models.Job, error) {
+	var jobs []models.Job
+	err := c.do(http.MethodGet, "/agents/"+url.PathEscape(agentID)+"/queue", nil, &jobs)
+	return jobs, err
+}
+
+// Cancel cancels a job that has not been dispatched yet
+func (c *Client) Cancel(jobID string) (models.Job, error) {
+	var job models.Job
+	err := c.do(http.MethodPost, "/jobs/"+url.PathEscape(jobID)+"/cancel", nil, &job)
+	return job, err
+}
+
+// SetPriority changes the priority of a queued job
+func (c *Client) SetPriority(jobID string, priority int) (models.Job, error) {
+	var job models.Job
+	err := c.do(http.MethodPost, "/jobs/"+url.PathEscape(jobID)+"/priority", models.PriorityRequest{Priority: priority}, &job)
+	return job, err
+}
+
+// Move hands a queued job to another agent
+func (c *Client) Move(jobID string, agentID string) (models.Job, error) {
+	var job models.Job
+	err := c.do(http.MethodPost, "/jobs/"+url.PathEscape(jobID)+"/move", models.MoveRequest{AgentID: agentID}, &job)
+	return job, err
+}
+
+// do sends a request to the control API and decodes the JSON response into v
+func (c *Client) do(method string, path string, body any, v any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshaling request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	return c.send(method, path, "application/json", reader, v)
+}
+
+// send makes a request with an already encoded body and decodes the JSON response into v
+func (c *Client) send(method string, path string, contentType string, body io.Reader, v any) error {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// The control API answers errors with a JSON string
+		var message string
+		if json.Unmarshal(data, &message) != nil {
+			message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, message)
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unmarshaling response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,795 @@
+package operator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"workshop3_dev/internals/models"
+
+	"golang.org/x/term"
+)
+
+// reconnectDelay is how long to wait before reopening a broken event stream
+const reconnectDelay = 2 * time.Second
+
+// watchedEvents are the event types the shell prints as they happen
+var watchedEvents = []string{
+	string(models.EventResultReceived),
+	string(models.EventJobTimedOut),
+	string(models.EventJobExpired),
+	string(models.EventBroadcastFinished),
+	string(models.EventAlert),
+	string(models.EventAgentRegistered),
+	string(models.EventAgentStale),
+	string(models.EventAgentDead),
+}
+
+// builtin is a shell command handled locally rather than queued for an agent
+type builtin struct {
+	usage    string
+	run      func(args []string) error
+	complete func() []string // Candidates for the first argument, if any
+}
+
+// Shell is an interactive prompt for tasking agents through the control API
+type Shell struct {
+	client   *Client
+	term     *term.Terminal // Only set when running interactively
+	out      io.Writer
+	readLine func() (string, error)
+	builtins map[string]builtin
+	agentID  string                        // Agent that commands are sent to
+	tag      string                        // Or the tag they are broadcast to, selected with 'use @<tag>'
+	commands map[string]models.CommandInfo // Registry of commands we are allowed to run
+	agentIDs []string                      // Agent IDs offered for completion
+	tags     []string                      // Tags offered for completion, as @<tag>
+	jobIDs   []string                      // Job IDs offered for completion
+	watching map[string]bool               // Jobs whose result is printed as soon as it arrives
+	mu       sync.Mutex
+}
+
+// NewShell creates a Shell reading commands from in and printing to out.
+// Interactive shells get a prompt, line editing and tab completion, which needs in to be a raw terminal.
+func NewShell(client *Client, in io.Reader, out io.Writer, interactive bool) *Shell {
+	s := &Shell{
+		client:   client,
+		commands: make(map[string]models.CommandInfo),
+		watching: make(map[string]bool),
+	}
+
+	if interactive {
+		s.term = term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{in, out}, "operator> ")
+		s.term.AutoCompleteCallback = s.autoComplete
+		s.out = s.term
+		s.readLine = s.term.ReadLine
+	} else {
+		// Commands piped in from a script are read one per line
+		scanner := bufio.NewScanner(in)
+		s.out = out
+		s.readLine = func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+	}
+
+	s.builtins = map[string]builtin{
+		"help":     {usage: "help", run: s.help},
+		"agents":   {usage: "agents", run: s.listAgents},
+		"use":      {usage: "use <agent_id>|@<tag>", run: s.use, complete: s.knownTargets},
+		"tag":      {usage: "tag <agent_id> [tag...]", run: s.setTags, complete: s.knownAgents},
+		"info":     {usage: "info", run: s.info},
+		"jobs":     {usage: "jobs [all]", run: s.listJobs},
+		"job":      {usage: "job <job_id>", run: s.showJob, complete: s.knownJobs},
+		"alerts":   {usage: "alerts", run: s.listAlerts},
+		"payloads": {usage: "payloads", run: s.listPayloads},
+		"upload":   {usage: "upload <local_file> [name] [notes]", run: s.upload},
+		"queue":    {usage: "queue", run: s.listQueue},
+		"cancel":   {usage: "cancel <job_id>", run: s.cancel, complete: s.knownJobs},
+		"priority": {usage: "priority <job_id> <n>", run: s.setPriority, complete: s.knownJobs},
+		"move":     {usage: "move <job_id> <agent_id>", run: s.move, complete: s.knownJobs},
+		"exit":     {usage: "exit"},
+	}
+
+	return s
+}
+
+// Run reads and executes commands until exit, end of input or ctx is cancelled
+func (s *Shell) Run(ctx context.Context) error {
+	if err := s.refreshCommands(); err != nil {
+		return fmt.Errorf("loading command registry: %w", err)
+	}
+	if _, err := s.refreshAgents(); err != nil {
+		s.printf("warning: could not list agents: %v\n", err)
+	}
+
+	go s.watch(ctx)
+
+	s.printf("Connected, %d commands available. Type 'help' for a list.\n", len(s.commands))
+
+	for {
+		line, err := s.readLine()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		args := splitArgs(line)
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			return nil
+		}
+
+		if err := s.execute(args, line); err != nil {
+			s.printf("error: %v\n", err)
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// execute runs a builtin, or queues a command from the registry for the selected agent
+func (s *Shell) execute(args []string, line string) error {
+	if b, ok := s.builtins[args[0]]; ok {
+		return b.run(args[1:])
+	}
+
+	info, ok := s.commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command '%s', type 'help' for a list", args[0])
+	}
+
+	if s.agentID == "" && s.tag == "" {
+		return errors.New("select an agent with 'use <agent_id>' or a tag with 'use @<tag>' first")
+	}
+
+	arguments, err := buildArguments(info, args[1:], line)
+	if err != nil {
+		return err
+	}
+
+	var resp models.CommandResponse
+	if s.tag != "" {
+		resp, err = s.client.Broadcast(s.tag, info.Name, arguments)
+	} else {
+		resp, err = s.client.Task(s.agentID, info.Name, arguments)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.watching[resp.JobID] = true
+	s.jobIDs = appendUnique(s.jobIDs, resp.JobID)
+	for _, child := range resp.Children {
+		s.jobIDs = appendUnique(s.jobIDs, child)
+	}
+	s.mu.Unlock()
+
+	if len(resp.Report) > 0 {
+		s.printReport(resp.Report)
+	}
+
+	if s.tag != "" {
+		s.printf("Queued %s as %s for %d agents tagged %s, results will be printed as they arrive\n",
+			info.Name, resp.JobID, len(resp.Children), s.tag)
+		return nil
+	}
+	s.printf("Queued %s as %s, the result will be printed when it arrives\n", info.Name, resp.JobID)
+	return nil
+}
+
+// buildArguments maps positional arguments onto the registry's argument names, or passes raw JSON through
+func buildArguments(info models.CommandInfo, args []string, line string) (json.RawMessage, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "{") {
+		raw := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), info.Name))
+		if !json.Valid([]byte(raw)) {
+			return nil, errors.New("arguments are not valid JSON")
+		}
+		return json.RawMessage(raw), nil
+	}
+
+	if len(args) != len(info.Arguments) {
+		return nil, fmt.Errorf("usage: %s", commandUsage(info))
+	}
+
+	named := make(map[string]string, len(args))
+	for i, name := range info.Arguments {
+		named[name] = args[i]
+	}
+
+	return json.Marshal(named)
+}
+
+func (s *Shell) help(args []string) error {
+	w := tabwriter.NewWriter(s.out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "Shell commands:")
+	for _, name := range sortedKeys(s.builtins) {
+		fmt.Fprintf(w, "  %s\n", s.builtins[name].usage)
+	}
+
+	fmt.Fprintln(w, "Agent commands (need a selected agent or tag, arguments may also be given as one JSON object):")
+	for _, name := range sortedKeys(s.commands) {
+		fmt.Fprintf(w, "  %s\t%s\n", commandUsage(s.commands[name]), s.commands[name].Description)
+	}
+
+	return w.Flush()
+}
+
+func (s *Shell) listAgents(args []string) error {
+	agents, err := s.refreshAgents()
+	if err != nil {
+		return err
+	}
+
+	if len(agents) == 0 {
+		s.printf("No agents have registered yet\n")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(s.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tUSER@HOST\tOS/ARCH\tLAST SEEN\tQUEUED\tTAGS")
+	for _, agent := range agents {
+		fmt.Fprintf(w, "%s\t%s\t%s@%s\t%s/%s\t%s ago\t%d\t%s\n",
+			agent.ID, agent.Status, agent.Username, agent.Hostname, agent.OS, agent.Arch,
+			time.Since(agent.LastSeen).Round(time.Second), agent.QueueDepth, strings.Join(agent.Tags, ","))
+	}
+	return w.Flush()
+}
+
+func (s *Shell) use(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: use <agent_id>|@<tag>")
+	}
+
+	if tag, ok := strings.CutPrefix(args[0], "@"); ok {
+		return s.useTag(tag)
+	}
+
+	agent, err := s.client.Agent(args[0])
+	if err != nil {
+		return err
+	}
+
+	s.agentID = agent.ID
+	s.tag = ""
+	if s.term != nil {
+		s.term.SetPrompt(fmt.Sprintf("operator[%s]> ", agent.ID))
+	}
+	s.printf("Using %s (%s@%s, %s)\n", agent.ID, agent.Username, agent.Hostname, agent.Status)
+	return nil
+}
+
+// useTag broadcasts the following commands to every agent carrying the tag
+func (s *Shell) useTag(tag string) error {
+	agents, err := s.refreshAgents()
+	if err != nil {
+		return err
+	}
+
+	tagged := 0
+	for _, agent := range agents {
+		if slices.Contains(agent.Tags, tag) {
+			tagged++
+		}
+	}
+	if tagged == 0 {
+		return fmt.Errorf("no agents are tagged %s", tag)
+	}
+
+	s.agentID = ""
+	s.tag = tag
+	if s.term != nil {
+		s.term.SetPrompt(fmt.Sprintf("operator[@%s]> ", tag))
+	}
+	s.printf("Broadcasting to the %d agents tagged %s\n", tagged, tag)
+	return nil
+}
+
+func (s *Shell) setTags(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: tag <agent_id> [tag...]")
+	}
+
+	agent, err := s.client.SetTags(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+
+	if len(agent.Tags) == 0 {
+		s.printf("%s has no tags\n", agent.ID)
+		return nil
+	}
+	s.printf("%s is tagged %s\n", agent.ID, strings.Join(agent.Tags, ", "))
+	return nil
+}
+
+func (s *Shell) info(args []string) error {
+	if s.agentID == "" {
+		return errors.New("no agent selected")
+	}
+
+	agent, err := s.client.Agent(s.agentID)
+	if err != nil {
+		return err
+	}
+
+	return s.printJSON(agent)
+}
+
+func (s *Shell) listJobs(args []string) error {
+	agentID := s.agentID
+	if len(args) > 0 && args[0] == "all" {
+		agentID = ""
+	}
+
+	jobs, err := s.client.RecentJobs(agentID, 20)
+	if err != nil {
+		return err
+	}
+
+	if len(jobs.Jobs) == 0 {
+		s.printf("No jobs\n")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(s.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tAGENT\tCOMMAND\tSTATUS\tQUEUED")
+	s.mu.Lock()
+	for _, job := range jobs.Jobs {
+		s.jobIDs = appendUnique(s.jobIDs, job.ID)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", job.ID, job.AgentID, job.Command, job.Status, job.QueuedAt.Local().Format(time.DateTime))
+	}
+	s.mu.Unlock()
+	fmt.Fprintf(w, "(%d of %d)\n", len(jobs.Jobs), jobs.Total)
+	return w.Flush()
+}
+
+func (s *Shell) showJob(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: job <job_id>")
+	}
+
+	job, err := s.client.Job(args[0])
+	if err != nil {
+		return err
+	}
+
+	s.printJob(job)
+	return nil
+}
+
+func (s *Shell) listQueue(args []string) error {
+	if s.agentID == "" {
+		return errors.New("no agent selected")
+	}
+
+	jobs, err := s.client.Queue(s.agentID)
+	if err != nil {
+		return err
+	}
+
+	if len(jobs) == 0 {
+		s.printf("Nothing queued for %s\n", s.agentID)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(s.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tID\tCOMMAND\tPRIORITY\tQUEUED")
+	s.mu.Lock()
+	for i, job := range jobs {
+		s.jobIDs = appendUnique(s.jobIDs, job.ID)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", i+1, job.ID, job.Command, job.Priority, job.QueuedAt.Local().Format(time.DateTime))
+	}
+	s.mu.Unlock()
+	return w.Flush()
+}
+
+func (s *Shell) cancel(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: cancel <job_id>")
+	}
+
+	job, err := s.client.Cancel(args[0])
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.watching, job.ID)
+	s.mu.Unlock()
+
+	s.printf("Cancelled %s\n", job.ID)
+	return nil
+}
+
+func (s *Shell) setPriority(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: priority <job_id> <n>")
+	}
+
+	priority, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid priority %q", args[1])
+	}
+
+	job, err := s.client.SetPriority(args[0], priority)
+	if err != nil {
+		return err
+	}
+
+	s.printf("%s now has priority %d\n", job.ID, job.Priority)
+	return nil
+}
+
+func (s *Shell) move(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: move <job_id> <agent_id>")
+	}
+
+	job, err := s.client.Move(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	s.printf("%s is now queued for %s\n", job.ID, job.AgentID)
+	return nil
+}
+
+func (s *Shell) listPayloads(args []string) error {
+	payloads, err := s.client.Payloads()
+	if err != nil {
+		return err
+	}
+
+	if len(payloads) == 0 {
+		s.printf("The payload library is empty, add to it with 'upload'\n")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(s.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSIZE\tSHA256\tUPLOADED\tNOTES")
+	for _, payload := range payloads {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s by %s\t%s\n", payload.ID, payload.Name, payload.Size, payload.SHA256,
+			payload.UploadedAt.Local().Format(time.DateTime), payload.UploadedBy, payload.Notes)
+	}
+	return w.Flush()
+}
+
+func (s *Shell) upload(args []string) error {
+	if len(args) < 1 || len(args) > 3 {
+		return errors.New("usage: upload <local_file> [name] [notes]")
+	}
+
+	var name, notes string
+	if len(args) > 1 {
+		name = args[1]
+	}
+	if len(args) > 2 {
+		notes = args[2]
+	}
+
+	payload, err := s.client.UploadPayload(args[0], name, notes)
+	if err != nil {
+		return err
+	}
+
+	s.printf("Stored %s as %s (%d bytes, sha256 %s)\n", payload.Name, payload.ID, payload.Size, payload.SHA256)
+	return nil
+}
+
+func (s *Shell) listAlerts(args []string) error {
+	alerts, err := s.client.Alerts(0)
+	if err != nil {
+		return err
+	}
+
+	if len(alerts) == 0 {
+		s.printf("No alerts\n")
+		return nil
+	}
+
+	for _, alert := range alerts {
+		s.printAlert(alert)
+	}
+	return nil
+}
+
+// watch follows the server's event stream, printing the results of watched jobs, alerts and agent news as they
+// happen. A broken stream, or one the server closed because the shell fell behind, is reopened where it left off.
+func (s *Shell) watch(ctx context.Context) {
+	var lastID uint64
+
+	for {
+		var err error
+		lastID, err = s.client.StreamEvents(ctx, lastID, watchedEvents, s.handleEvent)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.printf("warning: event stream: %v, reconnecting\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// handleEvent prints an event if the operator cares about it
+func (s *Shell) handleEvent(event models.Event) {
+	switch event.Type {
+	case models.EventResultReceived, models.EventJobTimedOut, models.EventJobExpired, models.EventBroadcastFinished:
+		// Runs of a watched recurring job are printed for as long as it keeps running
+		s.mu.Lock()
+		watched := s.watching[event.JobID] || s.watching[event.ParentID]
+		delete(s.watching, event.JobID)
+		s.mu.Unlock()
+
+		if !watched {
+			return
+		}
+		job, err := s.client.Job(event.JobID)
+		if err != nil {
+			s.printf("[%s] %s, but the job could not be fetched: %v\n", event.JobID, event.Type, err)
+			return
+		}
+		s.printJob(job)
+	case models.EventAlert:
+		s.printf("[ALERT %d] %s %s agent=%s job=%s: %s\n",
+			event.ID, event.Time.Local().Format(time.DateTime), event.Status, event.AgentID, event.JobID, event.Detail)
+	case models.EventAgentRegistered, models.EventAgentStale, models.EventAgentDead:
+		s.printf("[%s] %s %s\n", event.Type, event.AgentID, event.Detail)
+	}
+}
+
+func (s *Shell) printJob(job models.JobDetails) {
+	target := job.AgentID
+	if job.Tag != "" {
+		target = "@" + job.Tag
+	}
+	s.printf("[%s] %s on %s: %s\n", job.ID, job.Command, target, job.Status)
+	if job.Output != "" {
+		s.printf("  output: %s\n", job.Output)
+	}
+	if job.Result != nil && job.Result.Error != "" {
+		s.printf("  error: %s\n", job.Result.Error)
+	}
+	if len(job.Children) == 0 {
+		return
+	}
+
+	counts := make([]string, 0, len(job.Summary))
+	for status, n := range job.Summary {
+		counts = append(counts, fmt.Sprintf("%s=%d", status, n))
+	}
+	sort.Strings(counts)
+	s.printf("  %d jobs: %s\n", len(job.Children), strings.Join(counts, " "))
+	for _, child := range job.Children {
+		s.printf("  [%s] %s: %s\n", child.ID, child.AgentID, child.Status)
+	}
+}
+
+// printReport summarises what the server found out about a command's arguments while validating them
+func (s *Shell) printReport(raw json.RawMessage) {
+	var report models.DLLReport
+	if err := json.Unmarshal(raw, &report); err != nil || report.SHA256 == "" {
+		s.printf("%s\n", raw)
+		return
+	}
+
+	s.printf("DLL sha256 %s, %d bytes, image base 0x%x, %d bytes mapped\n",
+		report.SHA256, report.Size, report.ImageBase, report.SizeOfImage)
+	s.printf("  exports (%d): %s\n", len(report.Exports), strings.Join(report.Exports, ", "))
+	for _, library := range sortedKeys(report.Imports) {
+		functions := report.Imports[library]
+		s.printf("  imports %s (%d): %s\n", library, len(functions), strings.Join(functions, ", "))
+	}
+}
+
+func (s *Shell) printAlert(alert models.Alert) {
+	s.printf("[ALERT %d] %s %s agent=%s job=%s from=%s: %s\n",
+		alert.ID, alert.Time.Local().Format(time.DateTime), alert.Kind, alert.AgentID, alert.JobID, alert.SourceIP, alert.Detail)
+}
+
+func (s *Shell) printJSON(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.printf("%s\n", data)
+	return nil
+}
+
+// printf writes to the output, an interactive terminal redraws the prompt when output arrives mid-line
+func (s *Shell) printf(format string, args ...any) {
+	fmt.Fprintf(s.out, format, args...)
+}
+
+func (s *Shell) refreshCommands() error {
+	commands, err := s.client.Commands()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands = make(map[string]models.CommandInfo, len(commands))
+	for _, cmd := range commands {
+		s.commands[cmd.Name] = cmd
+	}
+	return nil
+}
+
+// refreshAgents fetches the agent list and remembers the IDs for completion
+func (s *Shell) refreshAgents() ([]models.AgentInfo, error) {
+	agents, err := s.client.Agents()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.agentIDs = s.agentIDs[:0]
+	s.tags = s.tags[:0]
+	for _, agent := range agents {
+		s.agentIDs = append(s.agentIDs, agent.ID)
+		for _, tag := range agent.Tags {
+			s.tags = appendUnique(s.tags, "@"+tag)
+		}
+	}
+	return agents, nil
+}
+
+func (s *Shell) knownAgents() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.agentIDs...)
+}
+
+func (s *Shell) knownTargets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(append([]string(nil), s.agentIDs...), s.tags...)
+}
+
+func (s *Shell) knownJobs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.jobIDs...)
+}
+
+// autoComplete completes command names, and agent or job IDs for the builtins that take them, on tab
+func (s *Shell) autoComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	prefix := line[:pos]
+	start := strings.LastIndex(prefix, " ") + 1
+	word := prefix[start:]
+
+	var candidates []string
+	if start == 0 {
+		candidates = append(sortedKeys(s.builtins), sortedKeys(s.commands)...)
+	} else {
+		fields := strings.Fields(prefix)
+		b, ok := s.builtins[fields[0]]
+		if !ok || b.complete == nil || len(fields) > 2 || (len(fields) == 2 && word == "") {
+			return "", 0, false
+		}
+		candidates = b.complete()
+	}
+
+	completion := commonPrefix(word, candidates)
+	if completion == "" || completion == word {
+		return "", 0, false
+	}
+
+	return line[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// commonPrefix returns the longest string extending word that every candidate starting with word shares
+func commonPrefix(word string, candidates []string) string {
+	common := ""
+	found := false
+
+	for _, candidate := range candidates {
+		if !strings.HasPrefix(candidate, word) {
+			continue
+		}
+		if !found {
+			common = candidate
+			found = true
+			continue
+		}
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	return common
+}
+
+// splitArgs splits a line on whitespace, keeping double quoted strings together
+func splitArgs(line string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes, inArg := false, false
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			inArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args
+}
+
+func commandUsage(info models.CommandInfo) string {
+	usage := info.Name
+	for _, arg := range info.Arguments {
+		usage += " <" + arg + ">"
+	}
+	return usage
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
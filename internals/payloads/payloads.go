@@ -0,0 +1,205 @@
+package payloads
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/storage"
+)
+
+// MaxSize is the largest payload the library accepts
+const MaxSize = 64 << 20
+
+var (
+	// ErrNotFound is returned for a reference that matches no payload
+	ErrNotFound = errors.New("unknown payload")
+	// ErrTooLarge is returned when an upload goes over MaxSize
+	ErrTooLarge = fmt.Errorf("payload is larger than %d bytes", MaxSize)
+)
+
+// Library keeps uploaded payloads on disk under their SHA-256, with their metadata in the datastore
+type Library struct {
+	dir      string
+	store    storage.Store
+	payloads map[string]*models.Payload
+	nextID   uint64
+	mu       sync.RWMutex
+}
+
+// Filter narrows down the payloads returned by List, zero values match everything
+type Filter struct {
+	Name   string // Case-insensitive substring of the name
+	SHA256 string
+}
+
+// Open loads the library kept in dir, whose metadata is stored in store
+func Open(dir string, store storage.Store) (*Library, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating payload directory: %w", err)
+	}
+
+	stored, err := store.LoadPayloads()
+	if err != nil {
+		return nil, fmt.Errorf("loading payloads: %w", err)
+	}
+
+	library := &Library{
+		dir:      dir,
+		store:    store,
+		payloads: make(map[string]*models.Payload, len(stored)),
+	}
+	for i := range stored {
+		library.payloads[stored[i].ID] = &stored[i]
+
+		var n uint64
+		if _, err := fmt.Sscanf(stored[i].ID, "payload_%d", &n); err == nil && n > library.nextID {
+			library.nextID = n
+		}
+	}
+
+	return library, nil
+}
+
+// Add stores the contents of r as a new payload. Uploading a file the library already holds returns the
+// existing payload and false rather than a second copy.
+func (l *Library) Add(name string, notes string, uploadedBy string, r io.Reader) (models.Payload, bool, error) {
+	// Write to a temporary file first, its final name is only known once it has been hashed
+	tmp, err := os.CreateTemp(l.dir, "upload-*")
+	if err != nil {
+		return models.Payload{}, false, fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return models.Payload{}, false, fmt.Errorf("writing payload: %w", err)
+	}
+	if size > MaxSize {
+		return models.Payload{}, false, ErrTooLarge
+	}
+	if err := tmp.Close(); err != nil {
+		return models.Payload{}, false, fmt.Errorf("writing payload: %w", err)
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, ok := l.bySHA256(digest); ok {
+		return *existing, false, nil
+	}
+
+	if err := os.Rename(tmp.Name(), l.path(digest)); err != nil {
+		return models.Payload{}, false, fmt.Errorf("storing payload: %w", err)
+	}
+
+	payload := models.Payload{
+		ID:         fmt.Sprintf("payload_%06d", l.nextID+1),
+		Name:       name,
+		SHA256:     digest,
+		Size:       size,
+		Notes:      notes,
+		UploadedBy: uploadedBy,
+		UploadedAt: time.Now(),
+	}
+
+	// Only listed once it is stored, so the library never shows a payload that a restart would lose
+	if err := l.store.SavePayload(payload); err != nil {
+		os.Remove(l.path(digest))
+		return models.Payload{}, false, fmt.Errorf("saving payload metadata: %w", err)
+	}
+
+	l.nextID++
+	l.payloads[payload.ID] = &payload
+
+	return payload, true, nil
+}
+
+// Get returns the payload with the given ID or SHA-256
+func (l *Library) Get(ref string) (models.Payload, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	payload, ok := l.lookup(ref)
+	if !ok {
+		return models.Payload{}, false
+	}
+	return *payload, true
+}
+
+// List returns the payloads matching the filter, oldest first
+func (l *Library) List(filter Filter) []models.Payload {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	matched := make([]models.Payload, 0)
+	for _, payload := range l.payloads {
+		if filter.Name != "" && !strings.Contains(strings.ToLower(payload.Name), strings.ToLower(filter.Name)) {
+			continue
+		}
+		if filter.SHA256 != "" && payload.SHA256 != strings.ToLower(filter.SHA256) {
+			continue
+		}
+		matched = append(matched, *payload)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].ID < matched[j].ID
+	})
+
+	return matched
+}
+
+// Read returns the contents of a payload, after checking they still match the hash recorded at upload
+func (l *Library) Read(ref string) ([]byte, models.Payload, error) {
+	payload, ok := l.Get(ref)
+	if !ok {
+		return nil, models.Payload{}, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+
+	data, err := os.ReadFile(l.path(payload.SHA256))
+	if err != nil {
+		return nil, models.Payload{}, fmt.Errorf("reading payload %s: %w", payload.ID, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != payload.SHA256 {
+		return nil, models.Payload{}, fmt.Errorf("payload %s has been modified on disk", payload.ID)
+	}
+
+	return data, payload, nil
+}
+
+// lookup finds a payload by ID or SHA-256, caller holds mu
+func (l *Library) lookup(ref string) (*models.Payload, bool) {
+	if payload, ok := l.payloads[ref]; ok {
+		return payload, true
+	}
+	return l.bySHA256(strings.ToLower(ref))
+}
+
+// bySHA256 finds a payload by its hash, caller holds mu
+func (l *Library) bySHA256(digest string) (*models.Payload, bool) {
+	for _, payload := range l.payloads {
+		if payload.SHA256 == digest {
+			return payload, true
+		}
+	}
+	return nil, false
+}
+
+// path is where the payload with the given hash is kept
+func (l *Library) path(digest string) string {
+	return filepath.Join(l.dir, digest)
+}
@@ -0,0 +1,129 @@
+package payloads
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"workshop3_dev/internals/storage"
+)
+
+func openLibrary(t *testing.T) *Library {
+	t.Helper()
+	library, err := Open(t.TempDir(), storage.NewNopStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return library
+}
+
+// leftovers lists what is in the library's directory besides the payloads it holds
+func leftovers(t *testing.T, l *Library) []string {
+	t.Helper()
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, ok := l.Get(entry.Name()); !ok {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestAddDeduplicates(t *testing.T) {
+	l := openLibrary(t)
+
+	first, created, err := l.Add("beacon.dll", "first upload", "alice", strings.NewReader("MZ payload"))
+	if err != nil || !created {
+		t.Fatalf("Add = %v, %v, want a new payload", created, err)
+	}
+
+	second, created, err := l.Add("renamed.dll", "", "bob", strings.NewReader("MZ payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || second != first {
+		t.Errorf("uploading the same bytes again gave %+v (created %v), want the first payload %+v", second, created, first)
+	}
+
+	other, created, err := l.Add("other.dll", "", "alice", strings.NewReader("MZ other"))
+	if err != nil || !created || other.ID == first.ID {
+		t.Errorf("Add of different bytes = %+v, %v, %v, want a second payload", other, created, err)
+	}
+
+	if got := l.List(Filter{}); len(got) != 2 {
+		t.Errorf("library lists %d payloads, want 2", len(got))
+	}
+	if names := leftovers(t, l); len(names) != 0 {
+		t.Errorf("files left next to the payloads: %v", names)
+	}
+}
+
+func TestAddSizeLimit(t *testing.T) {
+	l := openLibrary(t)
+
+	if _, _, err := l.Add("max.bin", "", "alice", io.LimitReader(repeat{}, MaxSize)); err != nil {
+		t.Fatalf("Add rejected a payload of exactly MaxSize: %v", err)
+	}
+
+	_, _, err := l.Add("huge.bin", "", "alice", io.LimitReader(repeat{1}, MaxSize+1))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Add of MaxSize+1 bytes = %v, want ErrTooLarge", err)
+	}
+	if got := l.List(Filter{}); len(got) != 1 {
+		t.Errorf("library lists %d payloads after the rejected upload, want 1", len(got))
+	}
+	if names := leftovers(t, l); len(names) != 0 {
+		t.Errorf("the rejected upload left files behind: %v", names)
+	}
+}
+
+func TestReadDetectsModification(t *testing.T) {
+	l := openLibrary(t)
+
+	payload, _, err := l.Add("beacon.dll", "", "alice", strings.NewReader("MZ payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{payload.ID, payload.SHA256, strings.ToUpper(payload.SHA256)} {
+		data, got, err := l.Read(ref)
+		if err != nil || !bytes.Equal(data, []byte("MZ payload")) || got.ID != payload.ID {
+			t.Errorf("Read(%s) = %q, %s, %v", ref, data, got.ID, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(l.dir, payload.SHA256), []byte("MZ swapped"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Read(payload.ID); err == nil || !strings.Contains(err.Error(), "modified on disk") {
+		t.Errorf("Read of a payload changed on disk = %v, want it to be refused", err)
+	}
+
+	if err := os.Remove(filepath.Join(l.dir, payload.SHA256)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Read(payload.ID); err == nil {
+		t.Error("Read of a payload removed from disk succeeded")
+	}
+
+	if _, _, err := l.Read("payload_999999"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read of an unknown payload = %v, want ErrNotFound", err)
+	}
+}
+
+// repeat is an endless stream of one byte value
+type repeat struct{ value byte }
+
+func (z repeat) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = z.value
+	}
+	return len(p), nil
+}
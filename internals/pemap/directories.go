@@ -0,0 +1,273 @@
+package pemap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	baseRelocationSize   = 8  // IMAGE_BASE_RELOCATION
+	importDescriptorSize = 20 // IMAGE_IMPORT_DESCRIPTOR
+	exportDirectorySize  = 40 // IMAGE_EXPORT_DIRECTORY
+)
+
+// relocate adds the distance between Base and the preferred image base to every DIR64 address in the image
+func (m *Mapped) relocate() error {
+	delta := m.Base - m.image.header.ImageBase
+	if delta == 0 {
+		return nil
+	}
+
+	dir := m.directory(imageDirectoryEntryBaseReloc)
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return fmt.Errorf("%w, it cannot be mapped at 0x%x instead of 0x%x", ErrNotRelocatable, m.Base, m.image.header.ImageBase)
+	}
+	table, err := view(m.Memory, uint64(dir.VirtualAddress), uint64(dir.Size))
+	if err != nil {
+		return fmt.Errorf("relocation directory: %w", err)
+	}
+
+	for len(table) >= baseRelocationSize {
+		pageRVA := binary.LittleEndian.Uint32(table[0:])
+		blockSize := binary.LittleEndian.Uint32(table[4:])
+		if pageRVA == 0 || blockSize <= baseRelocationSize {
+			break
+		}
+		if uint64(blockSize) > uint64(len(table)) {
+			return fmt.Errorf("block for page 0x%x (%d bytes) runs past the end of the directory", pageRVA, blockSize)
+		}
+
+		entries := table[baseRelocationSize:blockSize]
+		for i := 0; i+2 <= len(entries); i += 2 {
+			entry := binary.LittleEndian.Uint16(entries[i:])
+			kind, offset := entry>>12, entry&0xFFF
+
+			switch kind {
+			case imageRelBasedAbsolute:
+				// Padding to keep blocks 32-bit aligned
+			case imageRelBasedDir64:
+				target, err := view(m.Memory, uint64(pageRVA)+uint64(offset), 8)
+				if err != nil {
+					return fmt.Errorf("fixup in page 0x%x: %w", pageRVA, err)
+				}
+				binary.LittleEndian.PutUint64(target, binary.LittleEndian.Uint64(target)+delta)
+				m.Fixups++
+			default:
+				return fmt.Errorf("unsupported relocation type %d at 0x%x", kind, uint64(pageRVA)+uint64(offset))
+			}
+		}
+
+		table = table[blockSize:]
+	}
+
+	return nil
+}
+
+// resolveImports fills in the import address table of every library the image imports from
+func (m *Mapped) resolveImports() error {
+	dir := m.directory(imageDirectoryEntryImport)
+	if dir.VirtualAddress == 0 {
+		return nil
+	}
+
+	for rva := uint64(dir.VirtualAddress); ; rva += importDescriptorSize {
+		descriptor, err := view(m.Memory, rva, importDescriptorSize)
+		if err != nil {
+			return fmt.Errorf("import descriptor: %w", err)
+		}
+		lookupRVA := binary.LittleEndian.Uint32(descriptor[0:])   // OriginalFirstThunk
+		nameRVA := binary.LittleEndian.Uint32(descriptor[12:])    // Name
+		addressRVA := binary.LittleEndian.Uint32(descriptor[16:]) // FirstThunk
+		if lookupRVA == 0 && addressRVA == 0 {
+			return nil
+		}
+		if nameRVA == 0 || addressRVA == 0 {
+			return fmt.Errorf("import descriptor at 0x%x has no library name or import address table", rva)
+		}
+		// Without a separate lookup table the names are read from the address table before it is overwritten
+		if lookupRVA == 0 {
+			lookupRVA = addressRVA
+		}
+
+		library, err := cstring(m.Memory, uint64(nameRVA))
+		if err != nil {
+			return fmt.Errorf("library name: %w", err)
+		}
+		m.Libraries = append(m.Libraries, library)
+
+		for i := uint64(0); ; i += 8 {
+			lookup, err := view(m.Memory, uint64(lookupRVA)+i, 8)
+			if err != nil {
+				return fmt.Errorf("%s lookup table: %w", library, err)
+			}
+			thunk := binary.LittleEndian.Uint64(lookup)
+			if thunk == 0 {
+				break
+			}
+
+			imp := Import{Library: library}
+			if thunk&imageOrdinalFlag64 != 0 {
+				imp.Ordinal = uint16(thunk)
+			} else {
+				// Skip the two byte hint in front of the name
+				if imp.Name, err = cstring(m.Memory, uint64(uint32(thunk))+2); err != nil {
+					return fmt.Errorf("%s import name: %w", library, err)
+				}
+			}
+
+			address, err := m.resolver(imp)
+			if err != nil {
+				return fmt.Errorf("%s: %w", imp, err)
+			}
+			if address == 0 {
+				return fmt.Errorf("%s resolved to a NULL address", imp)
+			}
+
+			slot, err := view(m.Memory, uint64(addressRVA)+i, 8)
+			if err != nil {
+				return fmt.Errorf("%s import address table: %w", library, err)
+			}
+			binary.LittleEndian.PutUint64(slot, address)
+		}
+	}
+}
+
+// Export returns the address of a function the image exports by name. Exports forwarded to another library are
+// looked up with the resolver the image was mapped with.
+func (m *Mapped) Export(name string) (uint64, error) {
+	dir := m.directory(imageDirectoryEntryExport)
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return 0, fmt.Errorf("export %q not found, the image has no export directory", name)
+	}
+
+	exports, err := view(m.Memory, uint64(dir.VirtualAddress), exportDirectorySize)
+	if err != nil {
+		return 0, fmt.Errorf("export directory: %w", err)
+	}
+	numberOfFunctions := binary.LittleEndian.Uint32(exports[20:])
+	numberOfNames := binary.LittleEndian.Uint32(exports[24:])
+	functionsRVA := uint64(binary.LittleEndian.Uint32(exports[28:]))
+	namesRVA := uint64(binary.LittleEndian.Uint32(exports[32:]))
+	ordinalsRVA := uint64(binary.LittleEndian.Uint32(exports[36:]))
+
+	// The whole name table has to be in the image before any of it is read, however many names it claims
+	names, err := view(m.Memory, namesRVA, uint64(numberOfNames)*4)
+	if err != nil {
+		return 0, fmt.Errorf("export name table of %d names: %w", numberOfNames, err)
+	}
+
+	for i := uint64(0); i < uint64(numberOfNames); i++ {
+		exportName, err := cstring(m.Memory, uint64(binary.LittleEndian.Uint32(names[i*4:])))
+		if err != nil {
+			return 0, fmt.Errorf("export name %d: %w", i, err)
+		}
+		if exportName != name {
+			continue
+		}
+
+		ordinal, err := view(m.Memory, ordinalsRVA+i*2, 2)
+		if err != nil {
+			return 0, fmt.Errorf("export ordinal table: %w", err)
+		}
+		index := uint64(binary.LittleEndian.Uint16(ordinal))
+		if index >= uint64(numberOfFunctions) {
+			return 0, fmt.Errorf("export %q has ordinal index %d, the image exports %d functions", name, index, numberOfFunctions)
+		}
+		function, err := view(m.Memory, functionsRVA+index*4, 4)
+		if err != nil {
+			return 0, fmt.Errorf("export address table: %w", err)
+		}
+		rva := uint64(binary.LittleEndian.Uint32(function))
+
+		// An address inside the export directory is the name of the function it forwards to, not code
+		if rva >= uint64(dir.VirtualAddress) && rva < uint64(dir.VirtualAddress)+uint64(dir.Size) {
+			return m.forward(name, rva)
+		}
+		return m.Base + rva, nil
+	}
+
+	return 0, fmt.Errorf("export %q not found", name)
+}
+
+// Exports returns the names an image exports functions by, in the order its export table lists them. They are read
+// from the file as it is, so an image can be inspected without the memory to map it.
+func (img *Image) Exports() ([]string, error) {
+	dir := img.header.DataDirectory[imageDirectoryEntryExport]
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return nil, nil
+	}
+
+	section, err := img.section(uint64(dir.VirtualAddress))
+	if err != nil {
+		return nil, fmt.Errorf("export directory: %w", err)
+	}
+	exports, err := view(section, 0, exportDirectorySize)
+	if err != nil {
+		return nil, fmt.Errorf("export directory: %w", err)
+	}
+	numberOfFunctions := binary.LittleEndian.Uint32(exports[20:])
+	numberOfNames := binary.LittleEndian.Uint32(exports[24:])
+	namesRVA := uint64(binary.LittleEndian.Uint32(exports[32:]))
+
+	if numberOfNames == 0 {
+		return nil, nil
+	}
+	if numberOfNames > numberOfFunctions {
+		return nil, fmt.Errorf("%d names for %d functions", numberOfNames, numberOfFunctions)
+	}
+
+	// Every name has a 4 byte pointer in the table, a count the section cannot hold is refused before anything
+	// is allocated for it
+	section, err = img.section(namesRVA)
+	if err != nil {
+		return nil, fmt.Errorf("export name table: %w", err)
+	}
+	if uint64(numberOfNames) > uint64(len(section))/4 {
+		return nil, fmt.Errorf("export name table of %d names runs past the end of its section", numberOfNames)
+	}
+
+	names := make([]string, 0, numberOfNames)
+	for i := uint64(0); i < uint64(numberOfNames); i++ {
+		nameRVA := uint64(binary.LittleEndian.Uint32(section[i*4:]))
+		data, err := img.section(nameRVA)
+		if err != nil {
+			return nil, fmt.Errorf("export name %d: %w", i, err)
+		}
+		name, err := cstring(data, 0)
+		if err != nil {
+			return nil, fmt.Errorf("export name %d: %w", i, err)
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// forward resolves an export forwarded to "LIBRARY.Function" or "LIBRARY.#ordinal"
+func (m *Mapped) forward(name string, rva uint64) (uint64, error) {
+	target, err := cstring(m.Memory, rva)
+	if err != nil {
+		return 0, fmt.Errorf("export %q forwarder: %w", name, err)
+	}
+	library, function, ok := strings.Cut(target, ".")
+	if !ok || library == "" || function == "" {
+		return 0, fmt.Errorf("export %q is forwarded to %q, which is not LIBRARY.Function", name, target)
+	}
+
+	imp := Import{Library: library + ".dll", Name: function}
+	if ordinal, isOrdinal := strings.CutPrefix(function, "#"); isOrdinal {
+		n, err := strconv.ParseUint(ordinal, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("export %q is forwarded to %q, which has an invalid ordinal", name, target)
+		}
+		imp = Import{Library: library + ".dll", Ordinal: uint16(n)}
+	}
+
+	address, err := m.resolver(imp)
+	if err != nil {
+		return 0, fmt.Errorf("export %q forwarded to %s: %w", name, imp, err)
+	}
+	return address, nil
+}
@@ -0,0 +1,318 @@
+// Package pemap maps x64 PE images into memory the way the Windows loader would, without depending on Windows.
+// The caller supplies the memory and the address it will live at, and a Resolver for the functions the image
+// imports, so the same code runs in the agent and anywhere else.
+package pemap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	imageDOSSignature = 0x5A4D
+	imageNTSignature  = 0x00004550
+	imageNTOptional64 = 0x20b // PE32+
+
+	imageDirectoryEntryExport    = 0
+	imageDirectoryEntryImport    = 1
+	imageDirectoryEntryBaseReloc = 5
+
+	imageRelBasedAbsolute = 0
+	imageRelBasedDir64    = 10
+
+	imageOrdinalFlag64 = uint64(1) << 63
+
+	imageFileDLL = 0x2000 // IMAGE_FILE_DLL
+)
+
+// ErrNotRelocatable is returned when an image has to be mapped away from its preferred base but has no relocations
+var ErrNotRelocatable = errors.New("image has no relocation directory")
+
+type dosHeader struct {
+	Magic  uint16
+	_      [58]byte
+	Lfanew int32
+}
+
+type fileHeader struct {
+	Machine              uint16
+	NumberOfSections     uint16
+	TimeDateStamp        uint32
+	PointerToSymbolTable uint32
+	NumberOfSymbols      uint32
+	SizeOfOptionalHeader uint16
+	Characteristics      uint16
+}
+
+type dataDirectory struct {
+	VirtualAddress uint32
+	Size           uint32
+}
+
+type optionalHeader64 struct {
+	Magic                       uint16
+	MajorLinkerVersion          uint8
+	MinorLinkerVersion          uint8
+	SizeOfCode                  uint32
+	SizeOfInitializedData       uint32
+	SizeOfUninitializedData     uint32
+	AddressOfEntryPoint         uint32
+	BaseOfCode                  uint32
+	ImageBase                   uint64
+	SectionAlignment            uint32
+	FileAlignment               uint32
+	MajorOperatingSystemVersion uint16
+	MinorOperatingSystemVersion uint16
+	MajorImageVersion           uint16
+	MinorImageVersion           uint16
+	MajorSubsystemVersion       uint16
+	MinorSubsystemVersion       uint16
+	Win32VersionValue           uint32
+	SizeOfImage                 uint32
+	SizeOfHeaders               uint32
+	CheckSum                    uint32
+	Subsystem                   uint16
+	DllCharacteristics          uint16
+	SizeOfStackReserve          uint64
+	SizeOfStackCommit           uint64
+	SizeOfHeapReserve           uint64
+	SizeOfHeapCommit            uint64
+	LoaderFlags                 uint32
+	NumberOfRvaAndSizes         uint32
+	DataDirectory               [16]dataDirectory
+}
+
+type sectionHeader struct {
+	Name                 [8]byte
+	VirtualSize          uint32
+	VirtualAddress       uint32
+	SizeOfRawData        uint32
+	PointerToRawData     uint32
+	PointerToRelocations uint32
+	PointerToLinenumbers uint32
+	NumberOfRelocations  uint16
+	NumberOfLinenumbers  uint16
+	Characteristics      uint32
+}
+
+// Image is a parsed PE32+ file, ready to be mapped
+type Image struct {
+	data     []byte
+	file     fileHeader
+	header   optionalHeader64
+	sections []sectionHeader
+}
+
+// Import is a function an image imports, by name or, when Name is empty, by ordinal
+type Import struct {
+	Library string
+	Name    string
+	Ordinal uint16
+}
+
+func (imp Import) String() string {
+	if imp.Name == "" {
+		return fmt.Sprintf("%s!#%d", imp.Library, imp.Ordinal)
+	}
+	return imp.Library + "!" + imp.Name
+}
+
+// Resolver returns the address of an imported function, or of a function an export is forwarded to
+type Resolver func(Import) (uint64, error)
+
+// Mapped is an image laid out in memory at its final address, with relocations applied and imports resolved
+type Mapped struct {
+	Memory    []byte
+	Base      uint64
+	Fixups    int      // Relocations applied, zero when the image sits at its preferred base
+	Libraries []string // Libraries imports were resolved from, in import table order
+
+	image    *Image
+	resolver Resolver
+}
+
+// Parse reads the headers of an x64 PE32+ image
+func Parse(data []byte) (*Image, error) {
+	reader := bytes.NewReader(data)
+
+	var dos dosHeader
+	if err := binary.Read(reader, binary.LittleEndian, &dos); err != nil {
+		return nil, fmt.Errorf("reading DOS header: %w", err)
+	}
+	if dos.Magic != imageDOSSignature {
+		return nil, errors.New("invalid DOS signature")
+	}
+	if dos.Lfanew < 0 || int(dos.Lfanew) >= len(data) {
+		return nil, fmt.Errorf("NT headers offset %d is outside the file", dos.Lfanew)
+	}
+	if _, err := reader.Seek(int64(dos.Lfanew), 0); err != nil {
+		return nil, fmt.Errorf("seeking NT headers: %w", err)
+	}
+
+	var signature uint32
+	if err := binary.Read(reader, binary.LittleEndian, &signature); err != nil {
+		return nil, fmt.Errorf("reading PE signature: %w", err)
+	}
+	if signature != imageNTSignature {
+		return nil, errors.New("invalid PE signature")
+	}
+
+	var file fileHeader
+	if err := binary.Read(reader, binary.LittleEndian, &file); err != nil {
+		return nil, fmt.Errorf("reading file header: %w", err)
+	}
+
+	optionalStart, _ := reader.Seek(0, 1)
+	var header optionalHeader64
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading optional header: %w", err)
+	}
+	if header.Magic != imageNTOptional64 {
+		return nil, fmt.Errorf("optional header magic is 0x%x, only PE32+ (0x%x) images are supported", header.Magic, imageNTOptional64)
+	}
+	if header.SizeOfHeaders > header.SizeOfImage || int(header.SizeOfHeaders) > len(data) {
+		return nil, fmt.Errorf("headers (%d bytes) are larger than the image (%d bytes) or the file (%d bytes)",
+			header.SizeOfHeaders, header.SizeOfImage, len(data))
+	}
+	// Entries past NumberOfRvaAndSizes are not part of the header, whatever bytes happen to be there
+	for i := header.NumberOfRvaAndSizes; i < uint32(len(header.DataDirectory)); i++ {
+		header.DataDirectory[i] = dataDirectory{}
+	}
+
+	// Section headers follow the optional header, whose real size the file header gives
+	if _, err := reader.Seek(optionalStart+int64(file.SizeOfOptionalHeader), 0); err != nil {
+		return nil, fmt.Errorf("seeking section headers: %w", err)
+	}
+	sections := make([]sectionHeader, file.NumberOfSections)
+	if err := binary.Read(reader, binary.LittleEndian, sections); err != nil {
+		return nil, fmt.Errorf("reading section headers: %w", err)
+	}
+
+	return &Image{data: data, file: file, header: header, sections: sections}, nil
+}
+
+// ImageBase is the address the image was linked to run at
+func (img *Image) ImageBase() uint64 {
+	return img.header.ImageBase
+}
+
+// SizeOfImage is how much memory the mapped image takes up
+func (img *Image) SizeOfImage() uint32 {
+	return img.header.SizeOfImage
+}
+
+// Machine is the architecture the image was built for, an IMAGE_FILE_MACHINE_* value
+func (img *Image) Machine() uint16 {
+	return img.file.Machine
+}
+
+// IsDLL reports whether the image is a DLL rather than an executable
+func (img *Image) IsDLL() bool {
+	return img.file.Characteristics&imageFileDLL != 0
+}
+
+// Relocatable reports whether the image has relocations, without which it can only be mapped at ImageBase
+func (img *Image) Relocatable() bool {
+	dir := img.header.DataDirectory[imageDirectoryEntryBaseReloc]
+	return dir.VirtualAddress != 0 && dir.Size != 0
+}
+
+// Map lays the image out in mem, which will live at address base and must hold at least SizeOfImage bytes.
+// Sections are copied to their virtual addresses, relocations are applied for base, and every import is filled
+// in with the address resolver returns for it.
+func (img *Image) Map(mem []byte, base uint64, resolver Resolver) (*Mapped, error) {
+	if uint64(len(mem)) < uint64(img.header.SizeOfImage) {
+		return nil, fmt.Errorf("%d bytes of memory is too small for an image of %d bytes", len(mem), img.header.SizeOfImage)
+	}
+	mem = mem[:img.header.SizeOfImage]
+	clear(mem)
+
+	copy(mem, img.data[:img.header.SizeOfHeaders])
+
+	for _, section := range img.sections {
+		// Sections without raw data, like .bss, are left zeroed
+		if section.SizeOfRawData == 0 || section.PointerToRawData == 0 {
+			continue
+		}
+
+		source, err := view(img.data, uint64(section.PointerToRawData), uint64(section.SizeOfRawData))
+		if err != nil {
+			return nil, fmt.Errorf("section %s raw data: %w", sectionName(section.Name), err)
+		}
+		destination, err := view(mem, uint64(section.VirtualAddress), uint64(section.SizeOfRawData))
+		if err != nil {
+			return nil, fmt.Errorf("section %s virtual data: %w", sectionName(section.Name), err)
+		}
+		copy(destination, source)
+	}
+
+	mapped := &Mapped{Memory: mem, Base: base, image: img, resolver: resolver}
+
+	if err := mapped.relocate(); err != nil {
+		return nil, fmt.Errorf("applying relocations: %w", err)
+	}
+	if err := mapped.resolveImports(); err != nil {
+		return nil, fmt.Errorf("resolving imports: %w", err)
+	}
+
+	return mapped, nil
+}
+
+// EntryPoint returns the address of the image's entry point, zero if it has none
+func (m *Mapped) EntryPoint() uint64 {
+	if m.image.header.AddressOfEntryPoint == 0 {
+		return 0
+	}
+	return m.Base + uint64(m.image.header.AddressOfEntryPoint)
+}
+
+// directory returns one entry of the image's data directory
+func (m *Mapped) directory(index int) dataDirectory {
+	return m.image.header.DataDirectory[index]
+}
+
+// section returns the file data of the section holding rva, from rva to the end of the section's raw data
+func (img *Image) section(rva uint64) ([]byte, error) {
+	for _, section := range img.sections {
+		start := uint64(section.VirtualAddress)
+		if rva < start || rva-start >= uint64(section.SizeOfRawData) {
+			continue
+		}
+		raw, err := view(img.data, uint64(section.PointerToRawData), uint64(section.SizeOfRawData))
+		if err != nil {
+			return nil, fmt.Errorf("section %s raw data: %w", sectionName(section.Name), err)
+		}
+		return raw[rva-start:], nil
+	}
+	return nil, fmt.Errorf("RVA 0x%x is not inside any section's data", rva)
+}
+
+// view returns n bytes of buf at offset, or an error instead of panicking when they are not all there
+func view(buf []byte, offset uint64, n uint64) ([]byte, error) {
+	if offset > uint64(len(buf)) || n > uint64(len(buf))-offset {
+		return nil, fmt.Errorf("0x%x+%d is outside the %d byte image", offset, n, len(buf))
+	}
+	return buf[offset : offset+n], nil
+}
+
+// cstring returns the NUL terminated string at offset in buf
+func cstring(buf []byte, offset uint64) (string, error) {
+	if offset >= uint64(len(buf)) {
+		return "", fmt.Errorf("string at 0x%x is outside the %d byte image", offset, len(buf))
+	}
+	end := bytes.IndexByte(buf[offset:], 0)
+	if end < 0 {
+		return "", fmt.Errorf("string at 0x%x is not terminated", offset)
+	}
+	return string(buf[offset : offset+uint64(end)]), nil
+}
+
+func sectionName(name [8]byte) string {
+	n := bytes.IndexByte(name[:], 0)
+	if n == -1 {
+		n = len(name)
+	}
+	return string(name[:n])
+}
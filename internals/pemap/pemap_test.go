@@ -0,0 +1,348 @@
+package pemap
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata are written by testdata/gen.go, which describes them, except real.dll, real.exe and
+// real32.dll, which testdata/real/build.sh compiles and links with GNU ld
+const (
+	preferredBase = 0x180000000
+	otherBase     = 0x7ff600000000
+	runRVA        = 0x1300
+	pointerRVA    = 0x12f0 // Holds the address of Run, the one relocation
+	iatRVA        = 0x11e0 // Sleep, then #5
+)
+
+var errResolve = errors.New("resolver failed")
+
+// fakeResolver resolves the imports it has addresses for and fails for every other one
+type fakeResolver map[Import]uint64
+
+func (fr fakeResolver) resolve(imp Import) (uint64, error) {
+	address, ok := fr[imp]
+	if !ok {
+		return 0, errResolve
+	}
+	return address, nil
+}
+
+// resolver has every import the fixtures have, and the targets of their forwarded exports
+func resolver() fakeResolver {
+	return fakeResolver{
+		{Library: "KERNEL32.dll", Name: "Sleep"}:               0x7ffa00001000,
+		{Library: "KERNEL32.dll", Ordinal: 5}:                  0x7ffa00002000,
+		{Library: "KERNEL32.dll", Name: "GetCurrentProcessId"}: 0x7ffa00003000,
+		{Library: "OTHER.dll", Name: "Target"}:                 0x7ffb00001000,
+		{Library: "OTHER.dll", Ordinal: 7}:                     0x7ffb00002000,
+	}
+}
+
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func parse(t *testing.T, name string) *Image {
+	t.Helper()
+	img, err := Parse(fixture(t, name))
+	if err != nil {
+		t.Fatalf("Parse(%s) failed: %v", name, err)
+	}
+	return img
+}
+
+// mapAt maps a fixture into fresh memory at base
+func mapAt(t *testing.T, name string, base uint64, r fakeResolver) (*Mapped, error) {
+	t.Helper()
+	img := parse(t, name)
+	return img.Map(make([]byte, img.SizeOfImage()), base, r.resolve)
+}
+
+func TestParse(t *testing.T) {
+	img := parse(t, "good.dll")
+	if img.ImageBase() != preferredBase || img.SizeOfImage() != 0x2000 {
+		t.Errorf("image base 0x%x and size 0x%x, want 0x%x and 0x2000", img.ImageBase(), img.SizeOfImage(), preferredBase)
+	}
+	if img.Machine() != 0x8664 || !img.IsDLL() || !img.Relocatable() {
+		t.Errorf("machine 0x%x, DLL %v, relocatable %v, want an x64 relocatable DLL", img.Machine(), img.IsDLL(), img.Relocatable())
+	}
+	if parse(t, "norel.dll").Relocatable() {
+		t.Error("norel.dll is relocatable")
+	}
+
+	good := fixture(t, "good.dll")
+	patched := func(offset int, value uint32) []byte {
+		data := slices.Clone(good)
+		binary.LittleEndian.PutUint32(data[offset:], value)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"DOS header only", good[:0x40]},
+		{"no DOS signature", patched(0, 0)},
+		{"NT headers past the end", patched(0x3c, 0x10000)},
+		{"negative NT headers offset", patched(0x3c, 0x80000000)},
+		{"no PE signature", patched(0x40, 0)},
+		{"PE32", patched(0x58, 0x10b)},
+		{"headers larger than the image", patched(0x58+60, 0x4000)},
+		{"headers larger than the file", good[:0x1c0]},
+		{"truncated section headers", fixture(t, "truncatedheaders.dll")},
+		{"more sections than the file holds", patched(0x44, 0xffff8664)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Error("Parse accepted a broken image")
+			}
+		})
+	}
+}
+
+func TestMap(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   uint64
+		fixups int
+	}{
+		{"preferred base", preferredBase, 0},
+		{"other base", otherBase, 1},
+		{"lower base", 0x10000, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapped, err := mapAt(t, "good.dll", tt.base, resolver())
+			if err != nil {
+				t.Fatalf("Map failed: %v", err)
+			}
+			if mapped.Fixups != tt.fixups {
+				t.Errorf("%d fixups applied, want %d", mapped.Fixups, tt.fixups)
+			}
+
+			// The pointer to Run has to point at Run wherever the image ends up
+			if got := binary.LittleEndian.Uint64(mapped.Memory[pointerRVA:]); got != tt.base+runRVA {
+				t.Errorf("relocated pointer = 0x%x, want 0x%x", got, tt.base+runRVA)
+			}
+			if got := mapped.EntryPoint(); got != tt.base+runRVA {
+				t.Errorf("entry point = 0x%x, want 0x%x", got, tt.base+runRVA)
+			}
+
+			r := resolver()
+			for i, imp := range []Import{{Library: "KERNEL32.dll", Name: "Sleep"}, {Library: "KERNEL32.dll", Ordinal: 5}} {
+				if got := binary.LittleEndian.Uint64(mapped.Memory[iatRVA+i*8:]); got != r[imp] {
+					t.Errorf("import address table slot for %s = 0x%x, want 0x%x", imp, got, r[imp])
+				}
+			}
+			if !slices.Equal(mapped.Libraries, []string{"KERNEL32.dll"}) {
+				t.Errorf("libraries = %v, want [KERNEL32.dll]", mapped.Libraries)
+			}
+		})
+	}
+}
+
+// TestMapLinked maps real.dll, whose layout came from a linker rather than from gen.go
+func TestMapLinked(t *testing.T) {
+	img := parse(t, "real.dll")
+	if img.ImageBase() != preferredBase || img.SizeOfImage() != 0x6000 || !img.IsDLL() || !img.Relocatable() {
+		t.Fatalf("image base 0x%x, size 0x%x, DLL %v, relocatable %v, want a relocatable DLL of 0x6000 at 0x%x",
+			img.ImageBase(), img.SizeOfImage(), img.IsDLL(), img.Relocatable(), preferredBase)
+	}
+
+	mapped, err := img.Map(make([]byte, img.SizeOfImage()), otherBase, resolver().resolve)
+	if err != nil {
+		t.Fatalf("Map failed: %v", err)
+	}
+	if mapped.Fixups != 2 {
+		t.Errorf("%d fixups applied, want 2", mapped.Fixups)
+	}
+
+	// .data holds a pointer to calls and a pointer to Run
+	for _, pointer := range []struct{ rva, target uint64 }{{0x2000, 0x2008}, {0x2010, 0x1000}} {
+		if got := binary.LittleEndian.Uint64(mapped.Memory[pointer.rva:]); got != otherBase+pointer.target {
+			t.Errorf("pointer at 0x%x = 0x%x, want 0x%x", pointer.rva, got, otherBase+pointer.target)
+		}
+	}
+	if got := mapped.EntryPoint(); got != otherBase+0x1018 {
+		t.Errorf("entry point = 0x%x, want DllMain at 0x%x", got, otherBase+0x1018)
+	}
+
+	r := resolver()
+	for i, imp := range []Import{{Library: "KERNEL32.dll", Name: "GetCurrentProcessId"}, {Library: "KERNEL32.dll", Name: "Sleep"}} {
+		if got := binary.LittleEndian.Uint64(mapped.Memory[0x4040+i*8:]); got != r[imp] {
+			t.Errorf("import address table slot for %s = 0x%x, want 0x%x", imp, got, r[imp])
+		}
+	}
+	if !slices.Equal(mapped.Libraries, []string{"KERNEL32.dll"}) {
+		t.Errorf("libraries = %v, want [KERNEL32.dll]", mapped.Libraries)
+	}
+
+	exports := []struct {
+		name string
+		want uint64
+	}{
+		{"Run", otherBase + 0x1000},
+		{"Other", otherBase + 0x101e},
+		{"Fwd", 0x7ffb00001000}, // OTHER.Target
+	}
+	for _, export := range exports {
+		if got, err := mapped.Export(export.name); err != nil || got != export.want {
+			t.Errorf("Export(%q) = 0x%x, %v, want 0x%x", export.name, got, err, export.want)
+		}
+	}
+
+	names, err := img.Exports()
+	if err != nil {
+		t.Fatalf("Exports failed: %v", err)
+	}
+	if want := []string{"Fwd", "Other", "Run"}; !slices.Equal(names, want) {
+		t.Errorf("exports = %v, want %v", names, want)
+	}
+
+	if parse(t, "real.exe").IsDLL() {
+		t.Error("real.exe is a DLL")
+	}
+	if _, err := Parse(fixture(t, "real32.dll")); err == nil {
+		t.Error("Parse accepted the 32-bit real32.dll")
+	}
+}
+
+func TestMapErrors(t *testing.T) {
+	missing := resolver()
+	delete(missing, Import{Library: "KERNEL32.dll", Ordinal: 5})
+
+	null := resolver()
+	null[Import{Library: "KERNEL32.dll", Name: "Sleep"}] = 0
+
+	tests := []struct {
+		name     string
+		fixture  string
+		base     uint64
+		resolver fakeResolver
+		is       error // When set, the error has to wrap it
+	}{
+		{"no relocations at another base", "norel.dll", otherBase, resolver(), ErrNotRelocatable},
+		{"missing import", "good.dll", otherBase, missing, errResolve},
+		{"resolver error", "good.dll", otherBase, fakeResolver{}, errResolve},
+		{"import resolved to NULL", "good.dll", otherBase, null, nil},
+		{"import directory out of bounds", "badimport.dll", otherBase, resolver(), nil},
+		{"relocation block past the directory", "badrelocblock.dll", otherBase, resolver(), nil},
+		{"relocation target out of bounds", "badreloctarget.dll", otherBase, resolver(), nil},
+		{"section data past the end of the file", "truncatedsections.dll", otherBase, resolver(), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mapAt(t, tt.fixture, tt.base, tt.resolver)
+			if err == nil {
+				t.Fatal("Map succeeded")
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("error %q does not wrap %q", err, tt.is)
+			}
+		})
+	}
+
+	t.Run("no relocations at the preferred base", func(t *testing.T) {
+		if _, err := mapAt(t, "norel.dll", preferredBase, resolver()); err != nil {
+			t.Errorf("Map failed: %v", err)
+		}
+	})
+
+	t.Run("memory smaller than the image", func(t *testing.T) {
+		img := parse(t, "good.dll")
+		if _, err := img.Map(make([]byte, img.SizeOfImage()-1), otherBase, resolver().resolve); err == nil {
+			t.Error("Map succeeded")
+		}
+	})
+}
+
+func TestExport(t *testing.T) {
+	mapped, err := mapAt(t, "good.dll", otherBase, resolver())
+	if err != nil {
+		t.Fatalf("Map failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want uint64
+	}{
+		{"Run", otherBase + runRVA},
+		{"Other", otherBase + runRVA},
+		{"Fwd", 0x7ffb00001000},    // OTHER.Target
+		{"FwdOrd", 0x7ffb00002000}, // OTHER.#7
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapped.Export(tt.name)
+			if err != nil {
+				t.Fatalf("Export(%q) failed: %v", tt.name, err)
+			}
+			if got != tt.want {
+				t.Errorf("Export(%q) = 0x%x, want 0x%x", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing export", func(t *testing.T) {
+		if _, err := mapped.Export("Missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("Export(Missing) = %v, want not found", err)
+		}
+	})
+
+	t.Run("forward the resolver cannot resolve", func(t *testing.T) {
+		r := resolver()
+		delete(r, Import{Library: "OTHER.dll", Name: "Target"})
+		mapped, err := mapAt(t, "good.dll", otherBase, r)
+		if err != nil {
+			t.Fatalf("Map failed: %v", err)
+		}
+		if _, err := mapped.Export("Fwd"); !errors.Is(err, errResolve) {
+			t.Errorf("Export(Fwd) = %v, want the resolver's error", err)
+		}
+	})
+
+	for _, name := range []string{"badexport.dll", "hugenames.dll"} {
+		t.Run(name, func(t *testing.T) {
+			mapped, err := mapAt(t, name, otherBase, resolver())
+			if err != nil {
+				t.Fatalf("Map failed: %v", err)
+			}
+			if _, err := mapped.Export("Run"); err == nil {
+				t.Error("Export found Run in a broken export directory")
+			}
+		})
+	}
+}
+
+func TestExports(t *testing.T) {
+	exports, err := parse(t, "good.dll").Exports()
+	if err != nil {
+		t.Fatalf("Exports failed: %v", err)
+	}
+	if want := []string{"Fwd", "FwdOrd", "Other", "Run"}; !slices.Equal(exports, want) {
+		t.Errorf("exports = %v, want %v", exports, want)
+	}
+
+	for _, name := range []string{"badexport.dll", "hugenames.dll"} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parse(t, name).Exports(); err == nil {
+				t.Error("Exports read a broken export directory")
+			}
+		})
+	}
+}
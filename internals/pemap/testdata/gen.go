@@ -0,0 +1,159 @@
+//go:build ignore
+
+// gen writes the synthetic DLL fixtures for the pemap tests. Run it from internals/pemap with: go run testdata/gen.go
+// The fixtures built by a real linker, real.dll, real.exe and real32.dll, come from real/build.sh instead.
+//
+// Every fixture is the same one section x64 DLL, linked at 0x180000000, with one thing changed. It exports Fwd
+// (forwarded to OTHER.Target), FwdOrd (forwarded to OTHER.#7), Other and Run, imports KERNEL32.dll!Sleep and
+// KERNEL32.dll!#5, and has one DIR64 relocation for the pointer to Run at RVA 0x12f0.
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	rva       = 0x1000
+	raw       = 0x200
+	imageBase = 0x180000000
+)
+
+var le = binary.LittleEndian
+
+// fixture is what to change about the DLL
+type fixture struct {
+	noRelocations  bool
+	importRVA      uint32 // Overrides the import directory address
+	exportRVA      uint32 // Overrides the export directory address
+	relocBlockSize uint32 // Overrides the relocation block size
+	relocTarget    uint16 // Overrides the page offset the relocation patches
+	numberOfNames  uint32 // Overrides the export directory's name count
+	truncate       int    // Cuts the file short
+}
+
+func main() {
+	fixtures := map[string]fixture{
+		"good.dll":              {},
+		"norel.dll":             {noRelocations: true},
+		"badimport.dll":         {importRVA: 0x1ff8},
+		"badexport.dll":         {exportRVA: 0x7000},
+		"badrelocblock.dll":     {relocBlockSize: 0x1000},
+		"badreloctarget.dll":    {relocTarget: 0xffc},
+		"hugenames.dll":         {numberOfNames: 0x40000001},
+		"truncatedheaders.dll":  {truncate: 0x150},
+		"truncatedsections.dll": {truncate: 0x300},
+	}
+
+	for name, f := range fixtures {
+		if err := os.WriteFile(filepath.Join("testdata", name), build(f), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func build(f fixture) []byte {
+	section := make([]byte, 0x400)
+	put32 := func(off int, values ...uint32) {
+		for i, v := range values {
+			le.PutUint32(section[off+i*4:], v)
+		}
+	}
+
+	// Export directory and its tables, names sorted as the loader expects, forwarder strings inside the directory
+	numberOfNames := uint32(4)
+	if f.numberOfNames != 0 {
+		numberOfNames = f.numberOfNames
+	}
+	put32(0x00, 0, 0, 0, rva+0x70, 1, numberOfNames, numberOfNames, rva+0x40, rva+0x50, rva+0x60)
+	put32(0x40, rva+0xa0, rva+0xb0, rva+0x300, rva+0x300)
+	put32(0x50, rva+0x80, rva+0x88, rva+0x90, rva+0x98)
+	for i := 0; i < 4; i++ {
+		le.PutUint16(section[0x60+i*2:], uint16(i))
+	}
+	copy(section[0x70:], "test.dll\x00")
+	copy(section[0x80:], "Fwd\x00")
+	copy(section[0x88:], "FwdOrd\x00")
+	copy(section[0x90:], "Other\x00")
+	copy(section[0x98:], "Run\x00")
+	copy(section[0xa0:], "OTHER.Target\x00")
+	copy(section[0xb0:], "OTHER.#7\x00")
+
+	// Import descriptor, lookup table, address table, hint/name and library name
+	put32(0x180, rva+0x1c0, 0, 0, rva+0x250, rva+0x1e0)
+	for _, table := range []int{0x1c0, 0x1e0} {
+		le.PutUint64(section[table:], rva+0x240)
+		le.PutUint64(section[table+8:], 1<<63|5)
+	}
+	copy(section[0x242:], "Sleep\x00")
+	copy(section[0x250:], "KERNEL32.dll\x00")
+
+	// One DIR64 relocation for a pointer to Run, padded to 12 bytes
+	blockSize, target := uint32(12), uint16(0x2f0)
+	if f.relocBlockSize != 0 {
+		blockSize = f.relocBlockSize
+	}
+	if f.relocTarget != 0 {
+		target = f.relocTarget
+	}
+	put32(0x200, rva, blockSize)
+	le.PutUint16(section[0x208:], 10<<12|target)
+	le.PutUint64(section[0x2f0:], imageBase+rva+0x300)
+
+	section[0x300] = 0xc3 // ret
+
+	optional := make([]byte, 240)
+	le.PutUint16(optional[0:], 0x20b)      // PE32+
+	le.PutUint32(optional[16:], rva+0x300) // AddressOfEntryPoint
+	le.PutUint64(optional[24:], imageBase)
+	le.PutUint32(optional[32:], 0x1000) // SectionAlignment
+	le.PutUint32(optional[36:], 0x200)  // FileAlignment
+	le.PutUint32(optional[56:], 0x2000) // SizeOfImage
+	le.PutUint32(optional[60:], raw)    // SizeOfHeaders
+	le.PutUint16(optional[68:], 2)      // Subsystem
+	le.PutUint32(optional[108:], 16)    // NumberOfRvaAndSizes
+	directory := func(index int, address, size uint32) {
+		le.PutUint32(optional[112+index*8:], address)
+		le.PutUint32(optional[116+index*8:], size)
+	}
+	exportRVA, importRVA := uint32(rva), uint32(rva+0x180)
+	if f.exportRVA != 0 {
+		exportRVA = f.exportRVA
+	}
+	if f.importRVA != 0 {
+		importRVA = f.importRVA
+	}
+	directory(0, exportRVA, 0xc0)
+	directory(1, importRVA, 40)
+	if !f.noRelocations {
+		directory(5, rva+0x200, 12)
+	}
+
+	file := make([]byte, raw+len(section))
+	copy(file, "MZ")
+	le.PutUint32(file[0x3c:], 0x40)
+	copy(file[0x40:], "PE\x00\x00")
+	coff := file[0x44:]
+	le.PutUint16(coff[0:], 0x8664)
+	le.PutUint16(coff[2:], 1) // NumberOfSections
+	le.PutUint16(coff[16:], uint16(len(optional)))
+	le.PutUint16(coff[18:], 0x2022) // DLL, executable, large address aware
+	copy(file[0x58:], optional)
+
+	header := file[0x58+len(optional):]
+	copy(header, ".rdata")
+	le.PutUint32(header[8:], uint32(len(section)))  // VirtualSize
+	le.PutUint32(header[12:], rva)                  // VirtualAddress
+	le.PutUint32(header[16:], uint32(len(section))) // SizeOfRawData
+	le.PutUint32(header[20:], raw)                  // PointerToRawData
+	le.PutUint32(header[36:], 0x40000040)
+
+	copy(file[raw:], section)
+
+	if f.truncate != 0 {
+		file = file[:f.truncate]
+	}
+	return file
+}
@@ -0,0 +1,29 @@
+#!/bin/sh
+# Run from internals/pemap/testdata/real. Needs gcc (with -m32 support) and GNU binutils with PE support, which
+# Debian's binutils has: the objects are compiled as ELF and converted to COFF, then linked by ld as PE.
+set -eu
+
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+cflags="-O1 -fno-pic -fno-asynchronous-unwind-tables -fno-stack-protector -fno-ident"
+ldflags="-s --no-insert-timestamp"
+
+coff() { # coff <source> <bfd target> [cflags...]
+	name=$(basename "$1" .c)
+	target=$2
+	shift 2
+	gcc $cflags "$@" -c "$name.c" -o "$work/$name.o"
+	objcopy -O "$target" --remove-section .note.GNU-stack "$work/$name.o" "$work/$name.obj"
+}
+
+coff kernel32.c pe-x86-64
+coff real.c pe-x86-64
+coff real32.c pe-i386 -m32 -fleading-underscore
+
+# ld links straight against a DLL, so a stand-in KERNEL32.dll gives real.dll a real import table
+ld -m i386pep --dll -e 0 --export-all-symbols $ldflags -o "$work/KERNEL32.dll" "$work/kernel32.obj"
+
+ld -m i386pep --dll -e DllMain --image-base 0x180000000 $ldflags -o ../real.dll "$work/real.obj" real.def "$work/KERNEL32.dll"
+ld -m i386pep -e Run --image-base 0x140000000 $ldflags -o ../real.exe "$work/real.obj" "$work/KERNEL32.dll"
+ld -m i386pe --dll -e 0 --export-all-symbols $ldflags -o ../real32.dll "$work/real32.obj"
@@ -0,0 +1,5 @@
+/* Stand-in KERNEL32.dll, only linked against by build.sh and never checked in */
+typedef unsigned int DWORD;
+
+__attribute__((ms_abi)) void Sleep(DWORD ms) {}
+__attribute__((ms_abi)) DWORD GetCurrentProcessId(void) { return 4; }
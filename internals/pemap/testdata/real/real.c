@@ -0,0 +1,21 @@
+/* real.dll, built by build.sh. Run calls into KERNEL32.dll through the thunks and IAT the linker creates, and the
+ * pointers in .data give the linker base relocations to emit. */
+typedef unsigned int DWORD;
+
+__attribute__((ms_abi)) void Sleep(DWORD);
+__attribute__((ms_abi)) DWORD GetCurrentProcessId(void);
+
+__attribute__((ms_abi)) int Run(void);
+
+__attribute__((ms_abi)) int (*entry)(void) = Run;
+int calls = 1;
+int *counter = &calls;
+
+__attribute__((ms_abi)) int DllMain(void *instance, DWORD reason, void *reserved) { return 1; }
+
+__attribute__((ms_abi)) int Run(void) {
+	Sleep(0);
+	return (int)GetCurrentProcessId();
+}
+
+__attribute__((ms_abi)) int Other(void) { return 2; }
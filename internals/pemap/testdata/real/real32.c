@@ -0,0 +1,2 @@
+/* real32.dll, a 32-bit DLL built by build.sh */
+int Run(void) { return 1; }
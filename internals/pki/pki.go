@@ -0,0 +1,374 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	caFile       = "ca.crt"
+	caKeyFile    = "ca.key"
+	leafFile     = "listener.crt"
+	leafKeyFile  = "listener.key"
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 90 * 24 * time.Hour
+	renewBefore  = 7 * 24 * time.Hour // Reissue a stored leaf this close to expiry
+)
+
+// Authority is a local certificate authority that issues the certificates used by HTTPS listeners
+type Authority struct {
+	dir    string
+	hosts  []string
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	leaf   *tls.Certificate
+	mu     sync.RWMutex
+}
+
+// Info describes the current CA and listener certificate, for agents to pin against
+type Info struct {
+	CAFingerprint   string    `json:"ca_fingerprint"` // Hex SHA-256 of the CA certificate (DER)
+	CAPEM           string    `json:"ca_pem"`
+	CANotAfter      time.Time `json:"ca_not_after"`
+	LeafFingerprint string    `json:"leaf_fingerprint"` // Hex SHA-256 of the listener certificate (DER)
+	LeafNotAfter    time.Time `json:"leaf_not_after"`
+	Hosts           []string  `json:"hosts"`
+}
+
+// Load opens the authority stored in dir, creating the CA and listener certificate on first start
+func Load(dir string, hosts []string) (*Authority, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating PKI directory: %w", err)
+	}
+
+	authority := &Authority{
+		dir:   dir,
+		hosts: withLocalHosts(hosts),
+	}
+
+	if err := authority.loadOrCreateCA(); err != nil {
+		return nil, err
+	}
+
+	if err := authority.loadOrIssueLeaf(); err != nil {
+		return nil, err
+	}
+
+	return authority, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate, so listeners always serve the latest leaf
+func (a *Authority) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.leaf, nil
+}
+
+// Rotate issues a new listener certificate from the same CA, running listeners pick it up on the next handshake
+func (a *Authority) Rotate() (Info, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.issueLeaf(); err != nil {
+		return Info{}, err
+	}
+
+	log.Printf("Rotated listener certificate, new fingerprint %s", Fingerprint(a.leaf.Leaf.Raw))
+
+	return a.info(), nil
+}
+
+// CertPool returns a pool holding only our CA, used to verify agent client certificates
+func (a *Authority) CertPool() *x509.CertPool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(a.caCert)
+	return pool
+}
+
+// IssueClientCert creates a client certificate and key for an agent build, returned as PEM
+func (a *Authority) IssueClientCert(name string) ([]byte, []byte, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating client key: %w", err)
+	}
+
+	template, err := newTemplate(name, leafValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.caCert, &key.PublicKey, a.caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating client certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshaling client key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	log.Printf("Issued client certificate %s, fingerprint %s", name, Fingerprint(der))
+
+	return certPEM, keyPEM, nil
+}
+
+// Info returns the fingerprints and validity of the current certificates
+func (a *Authority) Info() Info {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.info()
+}
+
+func (a *Authority) info() Info {
+	return Info{
+		CAFingerprint:   Fingerprint(a.caCert.Raw),
+		CAPEM:           string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.caCert.Raw})),
+		CANotAfter:      a.caCert.NotAfter,
+		LeafFingerprint: Fingerprint(a.leaf.Leaf.Raw),
+		LeafNotAfter:    a.leaf.Leaf.NotAfter,
+		Hosts:           a.hosts,
+	}
+}
+
+// Fingerprint returns the hex SHA-256 of a DER encoded certificate
+func Fingerprint(der []byte) string {
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:])
+}
+
+// loadOrCreateCA reads the CA from disk, or creates and stores a new one
+func (a *Authority) loadOrCreateCA() error {
+	certPath := filepath.Join(a.dir, caFile)
+	keyPath := filepath.Join(a.dir, caKeyFile)
+
+	cert, key, err := readPair(certPath, keyPath)
+	if err == nil {
+		a.caCert, a.caKey = cert, key
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("loading CA: %w", err)
+	}
+
+	// Agents pin this CA, so a new one is only created when there is none at all, never over half of one
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if !errors.Is(certErr, os.ErrNotExist) || !errors.Is(keyErr, os.ErrNotExist) {
+		return fmt.Errorf("loading CA: only one of %s and %s exists in %s, restore the other or remove both to create a new CA, which agents pinned to the old one will not trust",
+			caFile, caKeyFile, a.dir)
+	}
+
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating CA key: %w", err)
+	}
+
+	template, err := newTemplate("workshop3 team server CA", caValidity)
+	if err != nil {
+		return err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("creating CA certificate: %w", err)
+	}
+
+	if err := writePair(certPath, keyPath, der, key); err != nil {
+		return err
+	}
+
+	a.caCert, _ = x509.ParseCertificate(der)
+	a.caKey = key
+
+	log.Printf("Created new CA in %s, fingerprint %s", a.dir, Fingerprint(der))
+
+	return nil
+}
+
+// loadOrIssueLeaf reads the listener certificate from disk unless it is missing, close to expiry or from another CA
+func (a *Authority) loadOrIssueLeaf() error {
+	cert, key, err := readPair(filepath.Join(a.dir, leafFile), filepath.Join(a.dir, leafKeyFile))
+	if err == nil && time.Until(cert.NotAfter) > renewBefore && cert.CheckSignatureFrom(a.caCert) == nil {
+		a.leaf = &tls.Certificate{
+			Certificate: [][]byte{cert.Raw, a.caCert.Raw},
+			PrivateKey:  key,
+			Leaf:        cert,
+		}
+		return nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("loading listener certificate: %w", err)
+	}
+
+	return a.issueLeaf()
+}
+
+// issueLeaf creates a new listener certificate for the configured hosts and stores it
+func (a *Authority) issueLeaf() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating listener key: %w", err)
+	}
+
+	template, err := newTemplate("workshop3 listener", leafValidity)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range a.hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.caCert, &key.PublicKey, a.caKey)
+	if err != nil {
+		return fmt.Errorf("creating listener certificate: %w", err)
+	}
+
+	if err := writePair(filepath.Join(a.dir, leafFile), filepath.Join(a.dir, leafKeyFile), der, key); err != nil {
+		return err
+	}
+
+	leaf, _ := x509.ParseCertificate(der)
+
+	// Send the CA along with the leaf so agents can pin either of them
+	a.leaf = &tls.Certificate{
+		Certificate: [][]byte{der, a.caCert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+
+	return nil
+}
+
+// newTemplate returns a certificate template with a random serial number
+func newTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial number: %w", err)
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour), // Tolerate clock skew on the agent
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// readPair loads a PEM certificate and EC private key from disk
+func readPair(certPath string, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM data in %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", certPath, err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM data in %s", keyPath)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", keyPath, err)
+	}
+
+	return cert, key, nil
+}
+
+// writePair stores a certificate and its private key as PEM files, the key only readable by us
+func writePair(certPath string, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshaling key: %w", err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("writing %s: %w", keyPath, err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", certPath, err)
+	}
+
+	return nil
+}
+
+// withLocalHosts adds localhost, the hostname and every local interface address to the configured hosts
+func withLocalHosts(hosts []string) []string {
+	seen := make(map[string]bool)
+	var all []string
+
+	add := func(host string) {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			all = append(all, host)
+		}
+	}
+
+	for _, host := range hosts {
+		add(host)
+	}
+	add("localhost")
+	if hostname, err := os.Hostname(); err == nil {
+		add(hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				add(ipNet.IP.String())
+			}
+		}
+	}
+
+	return all
+}
@@ -0,0 +1,72 @@
+package replay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStale is returned for messages issued outside the accepted window
+	ErrStale = errors.New("message is stale")
+	// ErrReplayed is returned for a nonce or job ID that has already been seen
+	ErrReplayed = errors.New("message was replayed")
+)
+
+// NewNonce returns a random identifier for a single message
+func NewNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand should never fail, but fall back to a time-based nonce rather than panic
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// CheckFresh rejects a message issued more than window before or after now
+func CheckFresh(issuedAt time.Time, now time.Time, window time.Duration) error {
+	if issuedAt.IsZero() {
+		return fmt.Errorf("%w: no timestamp", ErrStale)
+	}
+	if issuedAt.Before(now.Add(-window)) || issuedAt.After(now.Add(window)) {
+		return fmt.Errorf("%w: issued at %s", ErrStale, issuedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Cache remembers IDs until they could no longer be accepted anyway, so it never grows without bound
+type Cache struct {
+	seen map[string]time.Time // ID -> when it can be forgotten
+	mu   sync.Mutex
+}
+
+// NewCache creates an empty Cache
+func NewCache() *Cache {
+	return &Cache{seen: make(map[string]time.Time)}
+}
+
+// Remember records id until the given time, returning ErrReplayed if it is already remembered
+func (c *Cache) Remember(id string, until time.Time, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Forget everything that has expired
+	for seenID, expires := range c.seen {
+		if now.After(expires) {
+			delete(c.seen, seenID)
+		}
+	}
+
+	if id == "" {
+		return fmt.Errorf("%w: no nonce", ErrReplayed)
+	}
+	if _, exists := c.seen[id]; exists {
+		return fmt.Errorf("%w: %s", ErrReplayed, id)
+	}
+
+	c.seen[id] = until
+	return nil
+}
@@ -0,0 +1,145 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch bounds how far ahead Next looks, a schedule that never matches (e.g. 30 February) gives up after it
+const maxSearch = 4 * 366 * 24 * time.Hour
+
+// Cron is a parsed five field cron expression: minute hour day-of-month month day-of-week.
+// Each field takes *, a value, a range a-b, a step */n or a-b/n, or a comma separated list of those.
+type Cron struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool // Index 0 unused
+	months   [13]bool // Index 0 unused
+	weekdays [7]bool  // 0 is Sunday, 7 is accepted as Sunday too
+	// Like classic cron, when both day fields are restricted a time matches if either of them does
+	anyDay, anyWeekday bool
+}
+
+// ParseCron parses a cron expression
+func ParseCron(expr string) (*Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression needs 5 fields, got %d", len(fields))
+	}
+
+	c := &Cron{
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+
+	specs := []struct {
+		name     string
+		min, max int
+		set      func(int)
+	}{
+		{"minute", 0, 59, func(v int) { c.minutes[v] = true }},
+		{"hour", 0, 23, func(v int) { c.hours[v] = true }},
+		{"day of month", 1, 31, func(v int) { c.days[v] = true }},
+		{"month", 1, 12, func(v int) { c.months[v] = true }},
+		{"day of week", 0, 7, func(v int) { c.weekdays[v%7] = true }},
+	}
+
+	for i, spec := range specs {
+		if err := parseField(fields[i], spec.min, spec.max, spec.set); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.name, err)
+		}
+	}
+
+	return c, nil
+}
+
+// parseField expands one field and calls set for every value it covers
+func parseField(field string, min int, max int, set func(int)) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return fmt.Errorf("invalid value %q", first)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(last); err != nil {
+					return fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				// a/n means from a to the end of the field
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set(v)
+		}
+	}
+
+	return nil
+}
+
+// Next returns the first whole minute strictly after t that matches, or the zero time if none does within a few years
+func (c *Cron) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+
+	for next.Before(limit) {
+		if !c.months[next.Month()] {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.dayMatches(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.hours[next.Hour()] {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.minutes[next.Minute()] {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	day := c.days[t.Day()]
+	weekday := c.weekdays[t.Weekday()]
+
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
@@ -0,0 +1,97 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"4 fields", "* * * *"},
+		{"6 fields", "* * * * * *"},
+		{"empty", ""},
+		{"minute out of range", "60 * * * *"},
+		{"hour out of range", "0 24 * * *"},
+		{"day of month 0", "0 0 0 * *"},
+		{"day of month out of range", "0 0 32 * *"},
+		{"month out of range", "0 0 1 13 *"},
+		{"day of week out of range", "0 0 * * 8"},
+		{"negative value", "-1 * * * *"},
+		{"step 0", "*/0 * * * *"},
+		{"negative step", "*/-5 * * * *"},
+		{"step that is not a number", "*/x * * * *"},
+		{"reversed range", "0 17-9 * * *"},
+		{"range past the end", "0 9-24 * * *"},
+		{"not a number", "a * * * *"},
+		{"empty list entry", "1,,2 * * * *"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCron(tt.expr); err == nil {
+				t.Errorf("ParseCron(%q) accepted an invalid expression", tt.expr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			if parsed, err = time.Parse("2006-01-02 15:04:05", s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		after string
+		want  string // Empty when the schedule never matches
+	}{
+		{"every minute", "* * * * *", "2026-10-17 10:07", "2026-10-17 10:08"},
+		{"seconds are dropped", "* * * * *", "2026-10-17 10:07:42", "2026-10-17 10:08"},
+		{"strictly after", "30 10 * * *", "2026-10-17 10:30", "2026-10-18 10:30"},
+		{"step", "*/15 * * * *", "2026-10-17 10:07", "2026-10-17 10:15"},
+		{"step into the next hour", "*/15 * * * *", "2026-10-17 10:45", "2026-10-17 11:00"},
+		{"a/n step", "5/20 * * * *", "2026-10-17 10:06", "2026-10-17 10:25"},
+		{"a/n step wraps to a", "5/20 * * * *", "2026-10-17 10:46", "2026-10-17 11:05"},
+		{"range with a step", "0 8-18/4 * * *", "2026-10-17 12:01", "2026-10-17 16:00"},
+		{"list", "0 9,17 * * *", "2026-10-17 09:00", "2026-10-17 17:00"},
+		{"weekdays skip the weekend", "0 9 * * 1-5", "2026-10-16 10:00", "2026-10-19 09:00"},
+		{"7 is Sunday", "0 9 * * 7", "2026-10-16 10:00", "2026-10-18 09:00"},
+		{"day of month or weekday, weekday first", "0 0 13 * 5", "2026-10-01 00:00", "2026-10-02 00:00"},
+		{"day of month or weekday, day first", "0 0 13 * 5", "2026-10-09 00:00", "2026-10-13 00:00"},
+		{"day of month only", "0 0 13 * *", "2026-10-01 00:00", "2026-10-13 00:00"},
+		{"month rollover", "0 0 1 * *", "2026-10-17 10:00", "2026-11-01 00:00"},
+		{"year rollover", "0 0 1 1 *", "2026-12-31 23:59", "2027-01-01 00:00"},
+		{"29 February", "0 0 29 2 *", "2026-10-17 10:00", "2028-02-29 00:00"},
+		{"30 February never comes", "0 0 30 2 *", "2026-10-17 10:00", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q) failed: %v", tt.expr, err)
+			}
+
+			got := cron.Next(at(tt.after))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next(%s) = %s, want the zero time", tt.after, got)
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.after, got.Format("2006-01-02 15:04 Mon"), want.Format("2006-01-02 15:04 Mon"))
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/pki"
+)
+
+const (
+	ProtocolHTTPS = "https"
+	ProtocolHTTP  = "http"
+)
+
+// managedListener pairs a listener's description with the server that implements it
+type managedListener struct {
+	info   models.Listener
+	server *Server
+}
+
+// Manager creates, starts and stops listeners at runtime
+type Manager struct {
+	listeners map[string]*managedListener
+	authority *pki.Authority // Issues certificates for HTTPS listeners without their own files
+	nextID    int
+	mu        sync.Mutex
+}
+
+// NewManager creates an empty listener manager, authority may be nil if every listener brings its own certificate
+func NewManager(authority *pki.Authority) *Manager {
+	return &Manager{
+		listeners: make(map[string]*managedListener),
+		authority: authority,
+	}
+}
+
+// Create validates a listener configuration and adds it to the manager without starting it
+func (m *Manager) Create(cfg models.ListenerConfig) (models.Listener, error) {
+	if cfg.Protocol == "" {
+		cfg.Protocol = ProtocolHTTPS
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return models.Listener{}, fmt.Errorf("invalid address: %w", err)
+	}
+
+	switch cfg.Protocol {
+	case ProtocolHTTPS:
+		if cfg.RequireClientCert && m.authority == nil {
+			return models.Listener{}, fmt.Errorf("client certificates need the local CA")
+		}
+		if cfg.CertFile == "" && cfg.KeyFile == "" {
+			if m.authority == nil {
+				return models.Listener{}, fmt.Errorf("https listener needs cert_file and key_file when there is no local CA")
+			}
+			break
+		}
+		for _, path := range []string{cfg.CertFile, cfg.KeyFile} {
+			if _, err := os.Stat(path); err != nil {
+				return models.Listener{}, fmt.Errorf("https listener needs both cert_file and key_file: %w", err)
+			}
+		}
+	case ProtocolHTTP:
+		if cfg.RequireClientCert {
+			return models.Listener{}, fmt.Errorf("client certificates need an https listener")
+		}
+		cfg.CertFile, cfg.KeyFile = "", ""
+	default:
+		return models.Listener{}, fmt.Errorf("unknown protocol: %s", cfg.Protocol)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ml := range m.listeners {
+		if cfg.Name != "" && ml.info.Name == cfg.Name {
+			return models.Listener{}, fmt.Errorf("a listener named %s already exists", cfg.Name)
+		}
+	}
+
+	m.nextID++
+	ml := &managedListener{
+		info: models.Listener{
+			ID:             fmt.Sprintf("listener_%d", m.nextID),
+			ListenerConfig: cfg,
+			CreatedAt:      time.Now(),
+		},
+	}
+	if ml.info.Name == "" {
+		ml.info.Name = ml.info.ID
+	}
+	m.listeners[ml.info.ID] = ml
+
+	log.Printf("Created %s listener %s (%s) on %s", cfg.Protocol, ml.info.ID, ml.info.Name, cfg.Address)
+
+	return ml.info, nil
+}
+
+// Start binds a listener's address and starts serving agents on it
+func (m *Manager) Start(id string) (models.Listener, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ml, exists := m.listeners[id]
+	if !exists {
+		return models.Listener{}, fmt.Errorf("unknown listener: %s", id)
+	}
+	if ml.info.Running {
+		return ml.info, fmt.Errorf("listener %s is already running", id)
+	}
+
+	if ml.info.Protocol == ProtocolHTTP {
+		log.Printf("WARNING: Listener %s uses plain HTTP, only use it in isolated labs", id)
+		ml.server = NewPlainServer(ml.info.Address)
+	} else if ml.info.CertFile == "" {
+		ml.server = NewAutoTLSServer(ml.info.Address, m.authority.GetCertificate)
+	} else {
+		ml.server = NewServer(ml.info.Address, ml.info.CertFile, ml.info.KeyFile)
+	}
+
+	if ml.info.RequireClientCert {
+		ml.server.RequireClientCerts(m.authority.CertPool())
+	}
+
+	// Bind synchronously so the operator gets the error straight away
+	if err := ml.server.Listen(); err != nil {
+		ml.info.Error = err.Error()
+		return ml.info, fmt.Errorf("starting listener %s: %w", id, err)
+	}
+
+	ml.info.Running = true
+	ml.info.Error = ""
+
+	srv := ml.server
+	log.Printf("Starting %s listener %s on %s", ml.info.Protocol, id, ml.info.Address)
+
+	go func() {
+		err := srv.Serve()
+		m.stopped(id, srv, err)
+	}()
+
+	return ml.info, nil
+}
+
+// stopped records that a listener's server has returned, keeping the error if it was not asked to stop
+func (m *Manager) stopped(id string, srv *Server, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ml, exists := m.listeners[id]
+	if !exists || ml.server != srv {
+		return
+	}
+
+	ml.info.Running = false
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Listener %s stopped with error: %v", id, err)
+		ml.info.Error = err.Error()
+	}
+}
+
+// Stop gracefully shuts a running listener down
+func (m *Manager) Stop(id string) (models.Listener, error) {
+	m.mu.Lock()
+	ml, exists := m.listeners[id]
+	if !exists {
+		m.mu.Unlock()
+		return models.Listener{}, fmt.Errorf("unknown listener: %s", id)
+	}
+	if !ml.info.Running {
+		info := ml.info
+		m.mu.Unlock()
+		return info, fmt.Errorf("listener %s is not running", id)
+	}
+	srv := ml.server
+	m.mu.Unlock()
+
+	// Shutdown can take a while, so don't hold the lock for it
+	err := srv.Stop()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ml.info.Running = false
+	log.Printf("Stopped listener %s", id)
+
+	return ml.info, err
+}
+
+// Delete removes a stopped listener
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ml, exists := m.listeners[id]
+	if !exists {
+		return fmt.Errorf("unknown listener: %s", id)
+	}
+	if ml.info.Running {
+		return fmt.Errorf("listener %s must be stopped before it is deleted", id)
+	}
+
+	delete(m.listeners, id)
+	log.Printf("Deleted listener %s", id)
+
+	return nil
+}
+
+// Get returns a single listener
+func (m *Manager) Get(id string) (models.Listener, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ml, exists := m.listeners[id]
+	if !exists {
+		return models.Listener{}, false
+	}
+	return ml.info, true
+}
+
+// List returns every listener, in the order they were created
+func (m *Manager) List() []models.Listener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	listeners := make([]models.Listener, 0, len(m.listeners))
+	for _, ml := range m.listeners {
+		listeners = append(listeners, ml.info)
+	}
+
+	sort.Slice(listeners, func(i, j int) bool {
+		return listeners[i].CreatedAt.Before(listeners[j].CreatedAt)
+	})
+
+	return listeners
+}
+
+// StopAll shuts down every running listener, used when the server exits
+func (m *Manager) StopAll() {
+	for _, listener := range m.List() {
+		if !listener.Running {
+			continue
+		}
+		if _, err := m.Stop(listener.ID); err != nil {
+			log.Printf("Error stopping listener %s: %v", listener.ID, err)
+		}
+	}
+}
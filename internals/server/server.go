@@ -2,39 +2,85 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
 	"time"
+	"workshop3_dev/internals/audit"
 	"workshop3_dev/internals/control"
+	"workshop3_dev/internals/events"
 	"workshop3_dev/internals/models"
+	"workshop3_dev/internals/replay"
+	"workshop3_dev/internals/session"
 )
 
-// Server implements the Server interface for HTTPS
+// Server implements the Server interface for HTTPS, or plain HTTP for isolated labs
 type Server struct {
-	addr    string
-	server  *http.Server
-	tlsCert string
-	tlsKey  string
+	addr     string
+	server   *http.Server
+	listener net.Listener
+	tlsCert  string
+	tlsKey   string
+	plain    bool
+	// getCertificate replaces the cert files when the certificate is managed by the local CA
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// clientCAs is set when agents must present a client certificate issued by one of these CAs
+	clientCAs *x509.CertPool
 }
 
 // NewServer creates a new HTTPS server
-func NewServer(addr string) *Server {
+func NewServer(addr string, tlsCert string, tlsKey string) *Server {
 	return &Server{
 		addr:    addr,
-		tlsCert: "./certs/server.crt",
-		tlsKey:  "./certs/server.key",
+		tlsCert: tlsCert,
+		tlsKey:  tlsKey,
 	}
 }
 
+// NewAutoTLSServer creates a new HTTPS server whose certificate is looked up on every handshake, so it can be rotated
+func NewAutoTLSServer(addr string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *Server {
+	return &Server{
+		addr:           addr,
+		getCertificate: getCertificate,
+	}
+}
+
+// NewPlainServer creates a new server speaking plain HTTP, only meant for isolated labs
+func NewPlainServer(addr string) *Server {
+	return &Server{
+		addr:  addr,
+		plain: true,
+	}
+}
+
+// RequireClientCerts makes the server reject agents without a client certificate signed by clientCAs
+func (server *Server) RequireClientCerts(clientCAs *x509.CertPool) {
+	server.clientCAs = clientCAs
+}
+
 // Start implements Server.Start for HTTPS
 func (server *Server) Start() error {
+	if err := server.Listen(); err != nil {
+		return err
+	}
+
+	return server.Serve()
+}
+
+// Listen loads the certificate and binds the address, so that configuration errors surface before serving
+func (server *Server) Listen() error {
 	// Create Chi router
 	r := chi.NewRouter()
 
+	// Define our POST endpoint for first contact
+	r.Post("/register", RegisterHandler)
+
 	// Define our GET endpoint
 	r.Get("/", RootHandler)
 
@@ -47,34 +93,184 @@ func (server *Server) Start() error {
 		Handler: r,
 	}
 
-	// Start the server
-	return server.server.ListenAndServeTLS(server.tlsCert, server.tlsKey)
+	if server.getCertificate != nil {
+		server.server.TLSConfig = &tls.Config{
+			GetCertificate: server.getCertificate,
+		}
+	} else if !server.plain {
+		cert, err := tls.LoadX509KeyPair(server.tlsCert, server.tlsKey)
+		if err != nil {
+			return fmt.Errorf("loading certificate: %w", err)
+		}
+		server.server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+	}
+
+	if server.clientCAs != nil && server.server.TLSConfig != nil {
+		server.server.TLSConfig.ClientCAs = server.clientCAs
+		server.server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	listener, err := net.Listen("tcp", server.addr)
+	if err != nil {
+		return err
+	}
+	server.listener = listener
+
+	return nil
 }
 
-func RootHandler(w http.ResponseWriter, r *http.Request) {
+// Serve accepts agent connections until the server is stopped
+func (server *Server) Serve() error {
+	if server.plain {
+		return server.server.Serve(server.listener)
+	}
+
+	// The certificate is already in TLSConfig
+	return server.server.ServeTLS(server.listener, "", "")
+}
 
+// RegisterHandler assigns an ID to an agent on first contact and adds it to the registry
+func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Endpoint %s has been hit by agent\n", r.URL.Path)
 
-	var response models.ServerResponse
+	var req models.RegisterRequest
 
-	// Check for pending commands
-	cmd, exists := control.AgentCommands.GetCommand()
-	if exists {
-		log.Printf("Sending command to agent: %s\n", cmd.Command)
-		response.Job = true
-		response.Command = cmd.Command
-		response.Arguments = cmd.Arguments
-		response.JobID = fmt.Sprintf("job_%06d", rand.Intn(1000000))
-		log.Printf("Job ID: %s\n", response.JobID)
-	} else {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("ERROR: Failed to decode JSON: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode("error decoding JSON")
+		return
+	}
+
+	// Complete the key exchange, every later message to and from the agent is sealed with this key
+	serverKey, err := session.GenerateKey()
+	if err != nil {
+		log.Printf("ERROR: Failed to generate session key: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	serverPublic := serverKey.PublicKey().Bytes()
+
+	sessionKey, err := session.DeriveKey(serverKey, req.PublicKey, req.PublicKey, serverPublic)
+	if err != nil {
+		log.Printf("ERROR: Key exchange with %s failed: %v", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode("invalid public key")
+		return
+	}
+
+	agent := control.Agents.Register(req, r.RemoteAddr, sessionKey)
+
+	audit.Record(audit.Entry{
+		Action:   "agent_registered",
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		AgentID:  agent.ID,
+		Outcome:  fmt.Sprintf("%s@%s (%s/%s)", agent.Username, agent.Hostname, agent.OS, agent.Arch),
+	})
+
+	events.Publish(models.Event{
+		Type:    models.EventAgentRegistered,
+		AgentID: agent.ID,
+		Detail:  fmt.Sprintf("%s@%s (%s/%s) from %s", agent.Username, agent.Hostname, agent.OS, agent.Arch, audit.SourceIP(r.RemoteAddr)),
+	})
+
+	response := models.RegisterResponse{
+		AgentID:   agent.ID,
+		PublicKey: serverPublic,
+	}
+	control.Signer.SignRegistration(&response, req.PublicKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response: %v\n", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+func RootHandler(w http.ResponseWriter, r *http.Request) {
+
+	agentID := r.Header.Get(models.AgentIDHeader)
+
+	log.Printf("Endpoint %s has been hit by agent %s\n", r.URL.Path, agentID)
+
+	// Unknown agents have to register before they can receive tasks
+	if _, known := control.Agents.SessionKey(agentID); !known {
+		log.Printf("Rejected check-in from unregistered agent '%s'", agentID)
+		audit.Record(audit.Entry{
+			Action:   "checkin_rejected",
+			SourceIP: audit.SourceIP(r.RemoteAddr),
+			AgentID:  agentID,
+			Outcome:  "unknown agent",
+		})
+		http.Error(w, "unknown agent", http.StatusUnauthorized)
+		return
+	}
+
+	// The ID is no secret, only a check-in sealed with the agent's session key may touch its queue
+	if kind, err := authenticateCheckIn(r, agentID); err != nil {
+		control.RaiseAlert(models.Alert{
+			Kind:     kind,
+			AgentID:  agentID,
+			SourceIP: audit.SourceIP(r.RemoteAddr),
+			Detail:   "check-in refused: " + err.Error(),
+		})
+		http.Error(w, "invalid check-in", http.StatusForbidden)
+		return
+	}
+	control.Agents.CheckIn(agentID, r.RemoteAddr)
+
+	events.Publish(models.Event{
+		Type:    models.EventAgentCheckIn,
+		AgentID: agentID,
+		Detail:  audit.SourceIP(r.RemoteAddr),
+	})
+
+	// A job only counts as dispatched once its response is sealed, otherwise it waits for the next check-in
+	var envelope models.Envelope
+	job, exists, err := control.AgentCommands.GetCommand(agentID, func(job models.Job) error {
+		var err error
+		envelope, err = sealResponse(agentID, &job)
+		return err
+	})
+	if err == nil && !exists {
 		log.Printf("No commands in queue")
+		envelope, err = sealResponse(agentID, nil)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to seal response for agent %s: %v", agentID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if exists {
+		audit.Record(audit.Entry{
+			Action:     "job_dispatched",
+			SourceIP:   audit.SourceIP(r.RemoteAddr),
+			AgentID:    agentID,
+			JobID:      job.ID,
+			Command:    job.Command,
+			ArgsSHA256: audit.Digest(job.Arguments),
+			Outcome:    "dispatched",
+		})
+
+		events.Publish(models.Event{
+			Type:     models.EventJobDispatched,
+			AgentID:  agentID,
+			JobID:    job.ID,
+			ParentID: job.ParentID,
+			Command:  job.Command,
+			Status:   string(models.JobDispatched),
+		})
 	}
 
 	// Set content type to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	// Encode and send the response
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := json.NewEncoder(w).Encode(envelope); err != nil {
 		log.Printf("Error encoding response: %v\n", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -82,6 +278,67 @@ func RootHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// sealResponse builds the response to a check-in, carrying job if there is one, sealed for the agent
+func sealResponse(agentID string, job *models.Job) (models.Envelope, error) {
+	var response models.ServerResponse
+
+	if job != nil {
+		log.Printf("Sending command to agent: %s\n", job.Command)
+		response.Job = true
+		response.Command = job.Command
+		response.Arguments = job.Arguments
+		response.JobID = job.ID
+		log.Printf("Job ID: %s\n", response.JobID)
+
+		// Only jobs signed with the engagement key will be run by the agent
+		control.Signer.Sign(&response, agentID)
+	}
+
+	// Lets the agent tell this response apart from a replay of an earlier one
+	response.Nonce = replay.NewNonce()
+	response.IssuedAt = time.Now().UTC()
+
+	// Only the agent holding the session key can read the response
+	return seal(agentID, response)
+}
+
+// authenticateCheckIn opens the sealed CheckIn sent with a check-in and checks it is recent. On failure it also
+// returns the kind of alert to raise.
+func authenticateCheckIn(r *http.Request, agentID string) (string, error) {
+	proof := r.Header.Get(models.CheckInHeader)
+	if proof == "" {
+		return control.AlertTampered, fmt.Errorf("no %s header", models.CheckInHeader)
+	}
+
+	envelopeBytes, err := base64.StdEncoding.DecodeString(proof)
+	if err != nil {
+		return control.AlertTampered, fmt.Errorf("decoding %s: %w", models.CheckInHeader, err)
+	}
+	var envelope models.Envelope
+	if err := json.Unmarshal(envelopeBytes, &envelope); err != nil {
+		return control.AlertTampered, fmt.Errorf("decoding %s: %w", models.CheckInHeader, err)
+	}
+
+	var checkIn models.CheckIn
+	if err := open(agentID, session.CheckIn, envelope, &checkIn); err != nil {
+		return control.AlertTampered, err
+	}
+	if checkIn.AgentID != agentID {
+		return control.AlertTampered, fmt.Errorf("sealed check-in is for agent '%s'", checkIn.AgentID)
+	}
+
+	// Refuse anything issued outside the window, or a nonce we have already accepted inside it
+	now := time.Now()
+	if err := replay.CheckFresh(checkIn.IssuedAt, now, control.ReplayWindow); err != nil {
+		return control.AlertStale, err
+	}
+	if err := control.CheckInNonces.Remember(checkIn.Nonce, checkIn.IssuedAt.Add(control.ReplayWindow), now); err != nil {
+		return control.AlertReplayed, err
+	}
+
+	return "", nil
+}
+
 // Stop implements Server.Stop for HTTPS
 func (server *Server) Stop() error {
 	// If there's no server, nothing to stop
@@ -98,30 +355,155 @@ func (server *Server) Stop() error {
 
 // ResultHandler receives and displays the result from the Agent
 func ResultHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Endpoint %s has been hit by agent\n", r.URL.Path)
+	agentID := r.Header.Get(models.AgentIDHeader)
 
-	var result models.AgentTaskResult
+	log.Printf("Endpoint %s has been hit by agent %s\n", r.URL.Path, agentID)
+
+	if _, known := control.Agents.SessionKey(agentID); !known {
+		log.Printf("Rejected result from unregistered agent '%s'", agentID)
+		audit.Record(audit.Entry{
+			Action:   "result_rejected",
+			SourceIP: audit.SourceIP(r.RemoteAddr),
+			AgentID:  agentID,
+			Outcome:  "unknown agent",
+		})
+		http.Error(w, "unknown agent", http.StatusUnauthorized)
+		return
+	}
+
+	var envelope models.Envelope
 
 	// Decode the incoming result
-	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
 		log.Printf("ERROR: Failed to decode JSON: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode("error decoding JSON")
 		return
 	}
 
-	// Unmarshal the CommandResult to get the actual message string
-	var messageStr string
-	if len(result.CommandResult) > 0 {
-		if err := json.Unmarshal(result.CommandResult, &messageStr); err != nil {
-			log.Printf("ERROR: Failed to unmarshal CommandResult: %v", err)
-			messageStr = string(result.CommandResult) // Fallback to raw bytes as string
-		}
+	var result models.AgentTaskResult
+	if err := open(agentID, session.ToServer, envelope, &result); err != nil {
+		rejectResult(w, r, agentID, result, control.AlertTampered, err)
+		return
+	}
+
+	// Refuse anything issued outside the window, or a nonce we have already accepted inside it
+	now := time.Now()
+	if err := replay.CheckFresh(result.IssuedAt, now, control.ReplayWindow); err != nil {
+		rejectResult(w, r, agentID, result, control.AlertStale, err)
+		return
+	}
+	if err := control.ResultNonces.Remember(result.Nonce, result.IssuedAt.Add(control.ReplayWindow), now); err != nil {
+		rejectResult(w, r, agentID, result, control.AlertReplayed, err)
+		return
+	}
+
+	if result.AgentID != agentID {
+		log.Printf("ERROR: Result for job %s claims agent '%s' but was sent by '%s'", result.JobID, result.AgentID, agentID)
+		audit.Record(audit.Entry{
+			Action:   "result_rejected",
+			SourceIP: audit.SourceIP(r.RemoteAddr),
+			AgentID:  agentID,
+			JobID:    result.JobID,
+			Outcome:  "agent ID mismatch",
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode("agent ID mismatch")
+		return
 	}
 
+	// Only a result that opened with the session key and is fresh counts as hearing from the agent
+	control.Agents.CheckIn(agentID, r.RemoteAddr)
+
+	// Attach the result to its job
+	job, err := control.Jobs.Complete(result)
+	if err != nil {
+		log.Printf("ERROR: Could not record result: %v", err)
+		audit.Record(audit.Entry{
+			Action:   "result_rejected",
+			SourceIP: audit.SourceIP(r.RemoteAddr),
+			AgentID:  agentID,
+			JobID:    result.JobID,
+			Outcome:  err.Error(),
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(err.Error())
+		return
+	}
+
+	audit.Record(audit.Entry{
+		Action:   "result_received",
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		AgentID:  agentID,
+		JobID:    job.ID,
+		Command:  job.Command,
+		Outcome:  string(job.Status),
+	})
+
+	events.Publish(models.Event{
+		Type:     models.EventResultReceived,
+		AgentID:  agentID,
+		JobID:    job.ID,
+		ParentID: job.ParentID,
+		Command:  job.Command,
+		Status:   string(job.Status),
+		Detail:   result.Error,
+	})
+
+	// Unmarshal the CommandResult to get the actual message string
+	messageStr := control.DecodeCommandResult(result.CommandResult)
+
 	if !result.Success {
-		log.Printf("Job (ID: %s) has failed\nMessage: %s\nError: %v", result.JobID, messageStr, result.Error)
+		log.Printf("Job (ID: %s) on agent %s has failed\nMessage: %s\nError: %s", result.JobID, result.AgentID, messageStr, result.Error)
 	} else {
-		log.Printf("Job (ID: %s) has succeeded\nMessage: %s", result.JobID, messageStr)
+		log.Printf("Job (ID: %s) on agent %s has succeeded\nMessage: %s", result.JobID, result.AgentID, messageStr)
+	}
+}
+
+// rejectResult refuses a result that failed authentication or replay checks and raises an alert for it
+func rejectResult(w http.ResponseWriter, r *http.Request, agentID string, result models.AgentTaskResult, kind string, err error) {
+	control.RaiseAlert(models.Alert{
+		Kind:     kind,
+		AgentID:  agentID,
+		JobID:    result.JobID,
+		SourceIP: audit.SourceIP(r.RemoteAddr),
+		Detail:   err.Error(),
+	})
+
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode("invalid result")
+}
+
+// seal encrypts a response with the session key of the agent it is meant for
+func seal(agentID string, response models.ServerResponse) (models.Envelope, error) {
+	key, exists := control.Agents.SessionKey(agentID)
+	if !exists {
+		return models.Envelope{}, fmt.Errorf("no session key for agent %s", agentID)
+	}
+
+	plaintext, err := json.Marshal(response)
+	if err != nil {
+		return models.Envelope{}, err
 	}
+
+	return session.Seal(key, agentID, session.ToAgent, plaintext)
+}
+
+// open decrypts a message an agent sealed with its session key for direction into v
+func open(agentID string, direction session.Direction, envelope models.Envelope, v any) error {
+	key, exists := control.Agents.SessionKey(agentID)
+	if !exists {
+		return fmt.Errorf("no session key for agent %s", agentID)
+	}
+
+	plaintext, err := session.Open(key, agentID, direction, envelope)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return fmt.Errorf("decoding sealed message: %w", err)
+	}
+
+	return nil
 }
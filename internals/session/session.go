@@ -0,0 +1,106 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"workshop3_dev/internals/models"
+)
+
+// Direction is bound into every envelope so a message can't be reflected back at its sender, or passed off as
+// another kind of message going the same way
+type Direction string
+
+const (
+	ToAgent  Direction = "server->agent"
+	ToServer Direction = "agent->server"
+	CheckIn  Direction = "agent->server|check-in" // Check-in proofs, so a sealed result can't be replayed as one
+)
+
+// kdfLabel separates session keys from any other use of the shared secret
+const kdfLabel = "workshop3-session-v1"
+
+// GenerateKey creates an ephemeral X25519 key pair for one key exchange
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// DeriveKey completes the key exchange and returns the 32 byte AES key both sides end up with
+func DeriveKey(private *ecdh.PrivateKey, peerPublic []byte, agentPublic []byte, serverPublic []byte) ([]byte, error) {
+	peer, err := ecdh.X25519().NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer public key: %w", err)
+	}
+
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange failed: %w", err)
+	}
+
+	// Mix both public keys in so the key is tied to this exact exchange
+	mac := hmac.New(sha256.New, shared)
+	mac.Write([]byte(kdfLabel))
+	mac.Write(agentPublic)
+	mac.Write(serverPublic)
+
+	return mac.Sum(nil), nil
+}
+
+// Seal encrypts plaintext for one agent and direction with AES-256-GCM
+func Seal(key []byte, agentID string, direction Direction, plaintext []byte) (models.Envelope, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return models.Envelope{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return models.Envelope{}, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	return models.Envelope{
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, additionalData(agentID, direction)),
+	}, nil
+}
+
+// Open decrypts and authenticates an envelope sealed for agentID in the given direction
+func Open(key []byte, agentID string, direction Direction, envelope models.Envelope) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, additionalData(agentID, direction))
+	if err != nil {
+		return nil, errors.New("message failed authentication")
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("session key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func additionalData(agentID string, direction Direction) []byte {
+	return []byte(agentID + "|" + string(direction))
+}
@@ -0,0 +1,155 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"workshop3_dev/internals/models"
+)
+
+// Domains separate the different things the engagement key signs from each other
+const (
+	domain             = "workshop3-task-v1"
+	registrationDomain = "workshop3-register-v1"
+)
+
+// Signer signs the jobs handed to agents with the engagement key
+type Signer struct {
+	key ed25519.PrivateKey
+	ttl time.Duration
+}
+
+// LoadOrCreateSigner reads the engagement key at path, generating and storing a new one on first start
+func LoadOrCreateSigner(path string, ttl time.Duration) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createSigner(path, ttl)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading engagement key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing engagement key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", path)
+	}
+
+	return &Signer{key: key, ttl: ttl}, nil
+}
+
+func createSigner(path string, ttl time.Duration) (*Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating engagement key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling engagement key: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("writing engagement key: %w", err)
+	}
+
+	return &Signer{key: key, ttl: ttl}, nil
+}
+
+// PublicKey returns the base64 public key that agents are built with
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Sign binds a job to the agent it was dispatched to, sets its expiry and signs it
+func (s *Signer) Sign(resp *models.ServerResponse, agentID string) {
+	resp.AgentID = agentID
+	resp.ExpiresAt = time.Now().Add(s.ttl).UTC()
+	resp.Signature = ed25519.Sign(s.key, message(resp))
+}
+
+// SignRegistration signs the server's half of a session key exchange together with the agent's half
+func (s *Signer) SignRegistration(resp *models.RegisterResponse, agentPublic []byte) {
+	resp.Signature = ed25519.Sign(s.key, registrationMessage(resp, agentPublic))
+}
+
+// ParsePublicKey decodes a base64 public key as printed by the server
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decoding public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// Verify checks a job was signed by the engagement key, is meant for agentID and has not expired
+func Verify(pub ed25519.PublicKey, resp *models.ServerResponse, agentID string, now time.Time) error {
+	if len(resp.Signature) == 0 {
+		return errors.New("job is not signed")
+	}
+	if !ed25519.Verify(pub, message(resp), resp.Signature) {
+		return errors.New("invalid signature")
+	}
+	if resp.AgentID != agentID {
+		return fmt.Errorf("job is bound to agent %s", resp.AgentID)
+	}
+	if now.After(resp.ExpiresAt) {
+		return fmt.Errorf("job expired at %s", resp.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// VerifyRegistration checks the server's half of a key exchange was signed by the engagement key
+// and answers the key the agent actually sent
+func VerifyRegistration(pub ed25519.PublicKey, resp *models.RegisterResponse, agentPublic []byte) error {
+	if len(resp.Signature) == 0 {
+		return errors.New("registration is not signed")
+	}
+	if !ed25519.Verify(pub, registrationMessage(resp, agentPublic), resp.Signature) {
+		return errors.New("invalid registration signature")
+	}
+	return nil
+}
+
+// message builds the exact bytes that are signed, every field an attacker could swap is included
+func message(resp *models.ServerResponse) []byte {
+	argsDigest := sha256.Sum256(resp.Arguments)
+
+	return []byte(strings.Join([]string{
+		domain,
+		resp.JobID,
+		resp.AgentID,
+		resp.Command,
+		hex.EncodeToString(argsDigest[:]),
+		strconv.FormatInt(resp.ExpiresAt.UnixNano(), 10),
+	}, "\n"))
+}
+
+// registrationMessage builds the signed bytes for a key exchange, both public keys and the assigned ID
+func registrationMessage(resp *models.RegisterResponse, agentPublic []byte) []byte {
+	return []byte(strings.Join([]string{
+		registrationDomain,
+		resp.AgentID,
+		hex.EncodeToString(agentPublic),
+		hex.EncodeToString(resp.PublicKey),
+	}, "\n"))
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"workshop3_dev/internals/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	agentsBucket = []byte("agents")
+	jobsBucket   = []byte("jobs")
+	// Session keys are kept apart from agent records so they can never leak out through the API
+	sessionKeysBucket = []byte("session_keys")
+	payloadsBucket    = []byte("payloads") // Metadata only, the files themselves live in the payload directory
+)
+
+// jobRecord is how a job is stored on disk, unlike the API it has to keep the processed arguments
+type jobRecord struct {
+	models.Job
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// BoltStore implements Store on top of a single embedded bbolt database file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens (or creates) the database file at path
+func OpenBolt(path string) (*BoltStore, error) {
+	// Fail rather than hang forever if another server already holds the file
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{agentsBucket, jobsBucket, sessionKeysBucket, payloadsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return fmt.Errorf("creating bucket %s: %w", bucket, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// SaveAgent implements Store.SaveAgent
+func (bs *BoltStore) SaveAgent(agent models.Agent) error {
+	return bs.put(agentsBucket, agent.ID, agent)
+}
+
+// LoadAgents implements Store.LoadAgents
+func (bs *BoltStore) LoadAgents() ([]models.Agent, error) {
+	var agents []models.Agent
+
+	err := bs.forEach(agentsBucket, func(value []byte) error {
+		var agent models.Agent
+		if err := json.Unmarshal(value, &agent); err != nil {
+			return err
+		}
+		agents = append(agents, agent)
+		return nil
+	})
+
+	return agents, err
+}
+
+// SaveJob implements Store.SaveJob
+func (bs *BoltStore) SaveJob(job models.Job) error {
+	return bs.put(jobsBucket, job.ID, jobRecord{Job: job, Arguments: job.Arguments})
+}
+
+// LoadJobs implements Store.LoadJobs
+func (bs *BoltStore) LoadJobs() ([]models.Job, error) {
+	var jobs []models.Job
+
+	err := bs.forEach(jobsBucket, func(value []byte) error {
+		var record jobRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		record.Job.Arguments = record.Arguments
+		jobs = append(jobs, record.Job)
+		return nil
+	})
+
+	return jobs, err
+}
+
+// SaveSessionKey implements Store.SaveSessionKey
+func (bs *BoltStore) SaveSessionKey(agentID string, key []byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionKeysBucket).Put([]byte(agentID), key)
+	})
+}
+
+// LoadSessionKeys implements Store.LoadSessionKeys
+func (bs *BoltStore) LoadSessionKeys() (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionKeysBucket).ForEach(func(agentID, key []byte) error {
+			// bbolt values are only valid for the life of the transaction
+			keys[string(agentID)] = append([]byte(nil), key...)
+			return nil
+		})
+	})
+
+	return keys, err
+}
+
+// SavePayload implements Store.SavePayload
+func (bs *BoltStore) SavePayload(payload models.Payload) error {
+	return bs.put(payloadsBucket, payload.ID, payload)
+}
+
+// LoadPayloads implements Store.LoadPayloads
+func (bs *BoltStore) LoadPayloads() ([]models.Payload, error) {
+	var payloads []models.Payload
+
+	err := bs.forEach(payloadsBucket, func(value []byte) error {
+		var payload models.Payload
+		if err := json.Unmarshal(value, &payload); err != nil {
+			return err
+		}
+		payloads = append(payloads, payload)
+		return nil
+	})
+
+	return payloads, err
+}
+
+// Close implements Store.Close
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
+
+// put stores value as JSON under key in bucket
+func (bs *BoltStore) put(bucket []byte, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("marshaling %s/%s: %w", bucket, key, err)
+	}
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// forEach calls fn with every value stored in bucket
+func (bs *BoltStore) forEach(bucket []byte, fn func(value []byte) error) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(key, value []byte) error {
+			if err := fn(value); err != nil {
+				return fmt.Errorf("decoding %s/%s: %w", bucket, key, err)
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,34 @@
+package storage
+
+import "workshop3_dev/internals/models"
+
+// Store persists server state so that agents, queued tasks and job history survive a restart
+type Store interface {
+	SaveAgent(agent models.Agent) error
+	LoadAgents() ([]models.Agent, error)
+	SaveJob(job models.Job) error
+	LoadJobs() ([]models.Job, error)
+	SaveSessionKey(agentID string, key []byte) error
+	LoadSessionKeys() (map[string][]byte, error)
+	SavePayload(payload models.Payload) error
+	LoadPayloads() ([]models.Payload, error)
+	Close() error
+}
+
+// nopStore keeps nothing, state only lives in memory for the life of the process
+type nopStore struct{}
+
+// NewNopStore creates a Store that discards everything written to it
+func NewNopStore() Store {
+	return nopStore{}
+}
+
+func (nopStore) SaveAgent(models.Agent) error                { return nil }
+func (nopStore) LoadAgents() ([]models.Agent, error)         { return nil, nil }
+func (nopStore) SaveJob(models.Job) error                    { return nil }
+func (nopStore) LoadJobs() ([]models.Job, error)             { return nil, nil }
+func (nopStore) SaveSessionKey(string, []byte) error         { return nil }
+func (nopStore) LoadSessionKeys() (map[string][]byte, error) { return nil, nil }
+func (nopStore) SavePayload(models.Payload) error            { return nil }
+func (nopStore) LoadPayloads() ([]models.Payload, error)     { return nil, nil }
+func (nopStore) Close() error                                { return nil }
@@ -0,0 +1,261 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"workshop3_dev/internals/events"
+	"workshop3_dev/internals/models"
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery" // The event ID, the same across retries so receivers can deduplicate
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	maxAttempts     = 5
+	initialBackoff  = time.Second
+	maxBackoff      = 30 * time.Second
+	deliveryTimeout = 10 * time.Second
+	queueSize       = 256 // Events waiting for one target before new ones are dropped
+)
+
+// Target is a receiver of webhook deliveries and the events it wants
+type Target struct {
+	Name     string
+	URL      string
+	Secret   string
+	Events   []models.EventType
+	Commands []string // Empty means every command
+	Statuses []string // Empty means every status
+}
+
+// matches reports whether the target wants an event
+func (t Target) matches(event models.Event) bool {
+	if !slices.Contains(t.Events, event.Type) {
+		return false
+	}
+	if len(t.Commands) > 0 && !slices.Contains(t.Commands, event.Command) {
+		return false
+	}
+	if len(t.Statuses) > 0 && !slices.Contains(t.Statuses, event.Status) {
+		return false
+	}
+	return true
+}
+
+// Dispatcher delivers events from the event bus to webhook targets, each target has its own queue
+// so a slow or broken receiver never holds up the others
+type Dispatcher struct {
+	targets []Target
+	queues  []chan models.Event
+	client  *http.Client
+	backoff time.Duration // Wait before the first retry, doubled for each one after
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewDispatcher creates a Dispatcher for the given targets
+func NewDispatcher(targets []Target) *Dispatcher {
+	queues := make([]chan models.Event, len(targets))
+	for i := range queues {
+		queues[i] = make(chan models.Event, queueSize)
+	}
+
+	return &Dispatcher{
+		targets: targets,
+		queues:  queues,
+		client:  &http.Client{Timeout: deliveryTimeout},
+		backoff: initialBackoff,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start subscribes to the event bus and begins delivering
+func (d *Dispatcher) Start() {
+	// Only the event types some target wants take up room in the subscription
+	var types []models.EventType
+	for _, target := range d.targets {
+		for _, t := range target.Events {
+			if !slices.Contains(types, t) {
+				types = append(types, t)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return
+	}
+
+	lastID := events.LastID()
+	_, stream, unsubscribe := events.Subscribe(lastID, types...)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() { unsubscribe() }()
+
+		for {
+			select {
+			case <-d.done:
+				return
+			case event, ok := <-stream:
+				if !ok {
+					// Cut off for falling behind, pick up again from history after the last event taken
+					var backlog []models.Event
+					backlog, stream, unsubscribe = events.Subscribe(lastID, types...)
+					for _, event := range backlog {
+						lastID = event.ID
+						d.enqueue(event)
+					}
+					continue
+				}
+				lastID = event.ID
+				d.enqueue(event)
+			}
+		}
+	}()
+
+	for i := range d.targets {
+		d.wg.Add(1)
+		go func(target Target, queue chan models.Event) {
+			defer d.wg.Done()
+
+			for {
+				select {
+				case <-d.done:
+					return
+				case event := <-queue:
+					d.deliver(target, event)
+				}
+			}
+		}(d.targets[i], d.queues[i])
+	}
+
+	if len(d.targets) > 0 {
+		log.Printf("Delivering events to %d webhook targets", len(d.targets))
+	}
+}
+
+// Stop abandons pending deliveries and waits for the workers to exit
+func (d *Dispatcher) Stop() {
+	close(d.done)
+	d.wg.Wait()
+}
+
+// enqueue hands an event to every target that wants it
+func (d *Dispatcher) enqueue(event models.Event) {
+	for i, target := range d.targets {
+		if !target.matches(event) {
+			continue
+		}
+		select {
+		case d.queues[i] <- event:
+		default:
+			log.Printf("ERROR: Webhook %s is too far behind, dropped event %d (%s)", target.Name, event.ID, event.Type)
+		}
+	}
+}
+
+// deliver sends an event to a target, retrying with exponential backoff on network errors, 429 and 5xx
+func (d *Dispatcher) deliver(target Target, event models.Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("ERROR: Failed to marshal event %d for webhook %s: %v", event.ID, target.Name, err)
+		return
+	}
+
+	backoff := d.backoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		retry, err := d.post(target, event, body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt == maxAttempts {
+			log.Printf("ERROR: Giving up on event %d (%s) for webhook %s after %d attempts: %v", event.ID, event.Type, target.Name, attempt, err)
+			return
+		}
+
+		log.Printf("WARN: Webhook %s attempt %d for event %d failed, retrying in %v: %v", target.Name, attempt, event.ID, backoff, err)
+
+		select {
+		case <-d.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post makes a single delivery attempt, reporting whether a failure is worth retrying
+func (d *Dispatcher) post(target Target, event models.Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(event.Type))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(event.ID, 10))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(target.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver returned status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("receiver returned status %d", resp.StatusCode)
+	}
+}
+
+// Sign returns the signature header value for a delivery, an HMAC-SHA256 over the timestamp and body
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature, and that its timestamp is within tolerance of now so it can't be replayed later
+func Verify(secret string, timestamp string, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp is %v away from now", age.Round(time.Second))
+	}
+
+	if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
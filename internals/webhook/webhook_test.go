@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"workshop3_dev/internals/models"
+)
+
+const testBackoff = 20 * time.Millisecond
+
+// delivery is one request the stand-in receiver got
+type delivery struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+// receiver is a local stand-in for a webhook receiver, answering each attempt with the next status in line
+type receiver struct {
+	statuses   []int // Once used up, every further attempt gets 200
+	deliveries []delivery
+	mu         sync.Mutex
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	status := http.StatusOK
+	if n := len(rc.deliveries); n < len(rc.statuses) {
+		status = rc.statuses[n]
+	}
+	rc.deliveries = append(rc.deliveries, delivery{at: time.Now(), header: r.Header.Clone(), body: body})
+	w.WriteHeader(status)
+}
+
+// newTestDispatcher starts a stand-in receiver and a dispatcher delivering to it, without the event bus
+func newTestDispatcher(t *testing.T, statuses ...int) (*Dispatcher, Target, *receiver) {
+	t.Helper()
+
+	rc := &receiver{statuses: statuses}
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+
+	target := Target{
+		Name:   "test",
+		URL:    srv.URL,
+		Secret: "s3cret",
+		Events: []models.EventType{models.EventResultReceived},
+	}
+	d := NewDispatcher([]Target{target})
+	d.backoff = testBackoff
+
+	return d, target, rc
+}
+
+func testEvent() models.Event {
+	return models.Event{
+		ID:      42,
+		Type:    models.EventResultReceived,
+		AgentID: "agent_1",
+		JobID:   "job_000001",
+		Command: "shellcode",
+		Status:  string(models.JobCompleted),
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	d, target, rc := newTestDispatcher(t)
+	event := testEvent()
+
+	d.deliver(target, event)
+
+	if len(rc.deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(rc.deliveries))
+	}
+	got := rc.deliveries[0]
+
+	var received models.Event
+	if err := json.Unmarshal(got.body, &received); err != nil {
+		t.Fatalf("body is not an event: %v", err)
+	}
+	if received.ID != event.ID || received.JobID != event.JobID {
+		t.Errorf("received event %+v, want %+v", received, event)
+	}
+	if got.header.Get(EventHeader) != string(event.Type) {
+		t.Errorf("%s = %q, want %q", EventHeader, got.header.Get(EventHeader), event.Type)
+	}
+	if got.header.Get(DeliveryHeader) != "42" {
+		t.Errorf("%s = %q, want 42", DeliveryHeader, got.header.Get(DeliveryHeader))
+	}
+
+	timestamp := got.header.Get(TimestampHeader)
+	signature := got.header.Get(SignatureHeader)
+
+	// Computed independently of Sign, the signature covers "timestamp.body"
+	mac := hmac.New(sha256.New, []byte(target.Secret))
+	mac.Write([]byte(timestamp + "." + string(got.body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+
+	if err := Verify(target.Secret, timestamp, signature, got.body, time.Now(), time.Minute); err != nil {
+		t.Errorf("Verify rejected a genuine delivery: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		now       time.Time
+	}{
+		{"wrong secret", "other", timestamp, got.body, time.Now()},
+		{"modified body", target.Secret, timestamp, append(append([]byte(nil), got.body...), ' '), time.Now()},
+		{"modified timestamp", target.Secret, "1", got.body, time.Unix(1, 0)},
+		{"too old", target.Secret, timestamp, got.body, time.Now().Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.secret, tt.timestamp, signature, tt.body, tt.now, time.Minute); err == nil {
+				t.Error("Verify accepted a delivery it should have rejected")
+			}
+		})
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+	}{
+		{"503 then success", []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}, 3},
+		{"429 then success", []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, 3},
+		{"gives up after max attempts", []int{500, 500, 500, 500, 500, 500}, maxAttempts},
+		{"400 is not retried", []int{http.StatusBadRequest}, 1},
+		{"401 is not retried", []int{http.StatusUnauthorized}, 1},
+		{"404 is not retried", []int{http.StatusNotFound}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, target, rc := newTestDispatcher(t, tt.statuses...)
+
+			d.deliver(target, testEvent())
+
+			if len(rc.deliveries) != tt.attempts {
+				t.Fatalf("got %d attempts, want %d", len(rc.deliveries), tt.attempts)
+			}
+
+			// Every retry waits twice as long as the one before, give or take how long the requests took
+			wait := testBackoff
+			for i := 1; i < len(rc.deliveries); i++ {
+				if gap := rc.deliveries[i].at.Sub(rc.deliveries[i-1].at); gap < wait*9/10 {
+					t.Errorf("retry %d came %v after the previous attempt, want at least %v", i, gap, wait)
+				}
+				wait *= 2
+
+				if id := rc.deliveries[i].header.Get(DeliveryHeader); id != "42" {
+					t.Errorf("retry %d has %s %q, want the same ID as the first attempt", i, DeliveryHeader, id)
+				}
+			}
+		})
+	}
+}
+
+func TestFilters(t *testing.T) {
+	completed := testEvent()
+
+	other := completed
+	other.Command = "other"
+
+	failed := completed
+	failed.Status = string(models.JobFailed)
+
+	queued := completed
+	queued.Type = models.EventJobQueued
+
+	tests := []struct {
+		name   string
+		target Target
+		event  models.Event
+		want   bool
+	}{
+		{"matching event", Target{Events: []models.EventType{models.EventResultReceived}}, completed, true},
+		{"other event type", Target{Events: []models.EventType{models.EventResultReceived}}, queued, false},
+		{"no events configured", Target{}, completed, false},
+		{"matching command", Target{Events: []models.EventType{models.EventResultReceived}, Commands: []string{"shellcode"}}, completed, true},
+		{"other command", Target{Events: []models.EventType{models.EventResultReceived}, Commands: []string{"shellcode"}}, other, false},
+		{"matching status", Target{Events: []models.EventType{models.EventResultReceived}, Statuses: []string{"completed"}}, completed, true},
+		{"other status", Target{Events: []models.EventType{models.EventResultReceived}, Statuses: []string{"completed"}}, failed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDispatcher([]Target{tt.target})
+
+			d.enqueue(tt.event)
+
+			if got := len(d.queues[0]) == 1; got != tt.want {
+				t.Errorf("event queued = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}